@@ -1,13 +1,25 @@
 package agentman
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/consul/testutil"
+	"io/ioutil"
+	"log"
 	"math"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // TestInstance represents a single instance of a consul test server and its client.  May be alone or in a cluster.
@@ -18,43 +30,307 @@ type TestInstance struct {
 
 	server *testutil.TestServer
 	client *api.Client
+
+	// conf is retained after the server stops so the instance may be resumed
+	conf *testutil.TestServerConfig
+	// dir holds files owned by this instance which must survive the server stopping, removed once it is stopped
+	dir string
+	// ownsData is true when the data dir lives within dir rather than somewhere provided by the callback
+	ownsData bool
+	// apiConfig, if set, adjusts the config each api client is created with
+	apiConfig func(*api.Config)
+	// retainOnFailure leaves dir in place should the instance fail
+	retainOnFailure bool
+	// logs captures the output of the agent
+	logs *logBuffer
 }
 
-// NewTestInstance will attempt to create a new consul test server and api client
-func NewTestInstance(name string, cb testutil.ServerConfigCallback) (*TestInstance, error) {
-	var err error
+// NewTestInstance will attempt to create a new consul test server and api client.  Unless the callback specifies its
+// own DataDir, the data dir is created outside of the test server's temp dir so that it survives a restart.  It is
+// removed once the instance is stopped.
+func NewTestInstance(name string, cb testutil.ServerConfigCallback, opts ...Option) (*TestInstance, error) {
 	s := &TestInstance{
 		m:    new(sync.Mutex),
 		name: name,
+		logs: new(logBuffer),
+	}
+
+	o := buildOptions(opts)
+
+	s.apiConfig = o.apiConfig
+	s.retainOnFailure = o.retainDataOnFailure
+
+	if err := o.validate(); err != nil {
+		return nil, fmt.Errorf("invalid options for instance \"%s\": %s", name, err)
+	}
+
+	if o.startGate != nil {
+		o.startGate()
 	}
 
-	s.server, err = testutil.NewTestServerConfig(cb)
+	dir, err := ioutil.TempDir("", "agentman")
 	if err != nil {
-		return nil, err
+		return nil, &InstanceStartError{Name: name, Phase: PhaseServerStart, Err: err}
 	}
 
-	apiConf := api.DefaultConfig()
-	apiConf.Address = s.server.HTTPAddr
-	s.client, err = api.NewClient(apiConf)
+	dataDir := filepath.Join(dir, "data")
+
+	var extraConfigFile string
+	if o.extraConfig != "" {
+		extraConfigFile = filepath.Join(dir, "extra.json")
+		if err = ioutil.WriteFile(extraConfigFile, []byte(o.extraConfig), 0644); err != nil {
+			os.RemoveAll(dir)
+			return nil, &InstanceStartError{Name: name, Phase: PhaseServerStart, Err: err}
+		}
+	}
+
+	// config blocks the testutil config struct does not expose, generated from options
+	generated := make(map[string]interface{})
+	if o.telemetry != nil {
+		generated["telemetry"] = o.telemetry
+	}
+	if o.gossip != nil {
+		generated["gossip_lan"] = o.gossip.config()
+	}
+
+	var generatedConfigFile string
+	if len(generated) > 0 {
+		b, err := json.Marshal(generated)
+		if err == nil {
+			generatedConfigFile = filepath.Join(dir, "generated.json")
+			err = ioutil.WriteFile(generatedConfigFile, b, 0644)
+		}
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, &InstanceStartError{Name: name, Phase: PhaseServerStart, Err: err}
+		}
+	}
+
+	s.dir = dir
+
+	var portErr error
+	err = s.start(func(conf *testutil.TestServerConfig) {
+		conf.DataDir = dataDir
+		var picked testutil.TestPortConfig
+		if conf.Ports != nil {
+			picked = *conf.Ports
+		}
+		if cb != nil {
+			cb(conf)
+		}
+		if o.ports != nil && conf.Ports != nil {
+			applyPorts(conf.Ports, o.ports)
+		}
+		listenHTTP(conf)
+		portErr = claimPorts(conf, picked)
+		conf.Stdout = captureOutput(conf.Stdout, s.logs)
+		conf.Stderr = captureOutput(conf.Stderr, s.logs)
+		s.ownsData = conf.DataDir == dataDir
+		if o.logLevel != "" {
+			conf.LogLevel = o.logLevel
+		}
+		if o.nodeID != "" {
+			conf.NodeID = o.nodeID
+		}
+		if o.checkpoint {
+			conf.DisableCheckpoint = false
+			if !o.anonymousSignature {
+				conf.Args = append(conf.Args, "-hcl", "disable_anonymous_signature = true")
+			}
+		}
+		if generatedConfigFile != "" {
+			conf.Args = append(conf.Args, "-config-file", generatedConfigFile)
+		}
+		if extraConfigFile != "" {
+			conf.Args = append(conf.Args, "-config-file", extraConfigFile)
+		}
+		conf.Args = append(conf.Args, o.args...)
+		// repeated on the command line so the process may be located should it need to be killed
+		conf.Args = append(conf.Args, "-data-dir", conf.DataDir)
+	})
 	if err != nil {
-		s.server.Stop()
-		return nil, fmt.Errorf("error while creating api client for instance %s: %s", name, err)
+		s.cleanupDir(true)
+		if portErr != nil {
+			// consul's own bind failure is far less clear about the cause
+			return nil, &InstanceStartError{Name: name, Phase: PhaseServerStart, Err: portErr}
+		}
+		return nil, err
+	}
+
+	if o.postStart != nil {
+		if err = o.postStart(s); err != nil {
+			s.stop(true)
+			return nil, &InstanceStartError{Name: name, Phase: PhasePostStart, Err: err}
+		}
 	}
 
 	return s, nil
 }
 
+// start will attempt to create the underlying test server and api client.  Caller must hold lock or otherwise have
+// exclusive access.
+func (ti *TestInstance) start(cb testutil.ServerConfigCallback) error {
+	server, err := testutil.NewTestServerConfig(cb)
+	if err != nil {
+		return &InstanceStartError{Name: ti.name, Phase: PhaseServerStart, Err: err}
+	}
+
+	client, err := api.NewClient(ti.newClientConfig(server.Config))
+	if err != nil {
+		server.Stop()
+		return &InstanceStartError{Name: ti.name, Phase: PhaseClientCreate, Err: err}
+	}
+
+	ti.server = server
+	ti.client = client
+	ti.conf = server.Config
+
+	trackDataDir(server.Config.DataDir)
+
+	return nil
+}
+
+// newClientConfig returns the config api clients of the server described by conf are created with, adjusted by any
+// WithAPIConfig callback
+func (ti *TestInstance) newClientConfig(conf *testutil.TestServerConfig) *api.Config {
+	apiConf := api.DefaultConfig()
+	apiConf.Address = httpHostPort(conf, conf.Ports.HTTP)
+	if ti.apiConfig != nil {
+		ti.apiConfig(apiConf)
+		apiConf.Address = httpHostPort(conf, conf.Ports.HTTP)
+	}
+	return apiConf
+}
+
+// clientConfig returns the config this instance's api client was created with, reporting false if the instance is
+// defunct
+func (ti *TestInstance) clientConfig() (*api.Config, bool) {
+	ti.m.Lock()
+	defer ti.m.Unlock()
+	if ti.server == nil {
+		return nil, false
+	}
+	return ti.newClientConfig(ti.server.Config), true
+}
+
+// halt will stop the underlying test server while leaving the data dir in place
+func (ti *TestInstance) halt() error {
+	ti.m.Lock()
+	defer ti.m.Unlock()
+	return ti.stopServer()
+}
+
+// resume will attempt to start a new test server from the config of the previous one, re-using its data dir.  The
+// server does not bootstrap, as it is expected to recover its state from the data dir.
+func (ti *TestInstance) resume() error {
+	ti.m.Lock()
+	defer ti.m.Unlock()
+	if ti.server != nil {
+		return nil
+	}
+	return ti.startFromConf()
+}
+
+// respawn replaces a server whose process has exited unexpectedly with a new one resumed from its data dir.  An
+// instance which has been stopped is left alone, and the defunct error returned.
+func (ti *TestInstance) respawn() error {
+	ti.m.Lock()
+	defer ti.m.Unlock()
+	if ti.server == nil {
+		return ti.defunctErr()
+	}
+	// reaps the exited process, its error is expected
+	ti.stopServer()
+	return ti.startFromConf()
+}
+
+// startFromConf starts a new test server from the retained config, re-using its data dir.  Caller must hold lock.
+func (ti *TestInstance) startFromConf() error {
+	if !ti.ownsData || ti.dir == "" || ti.conf == nil {
+		return fmt.Errorf("instance %s has no preserved data dir to resume from", ti.name)
+	}
+
+	prev := *ti.conf
+	prev.Bootstrap = false
+
+	return ti.start(func(conf *testutil.TestServerConfig) {
+		*conf = prev
+	})
+}
+
+// removeDir removes the files owned by this instance, including the data dir if it lives there.  Caller must hold
+// lock.
+func (ti *TestInstance) removeDir() {
+	if ti.dir != "" {
+		os.RemoveAll(ti.dir)
+		ti.dir = ""
+	}
+}
+
+// cleanupDir removes the files owned by this instance, unless failed is true and the instance was created with
+// WithRetainDataOnFailure, in which case they are left in place and their location logged.  Caller must hold lock.
+func (ti *TestInstance) cleanupDir(failed bool) {
+	if !failed || !ti.retainOnFailure {
+		ti.removeDir()
+		return
+	}
+	if ti.dir != "" {
+		log.Printf("agentman: instance \"%s\" failed, its files have been retained at %s", ti.name, ti.dir)
+		ti.dir = ""
+	}
+}
+
+// stopServer stops the underlying test server and nils out both the server and the client.  Caller must hold lock.
+func (ti *TestInstance) stopServer() error {
+	if ti.server == nil {
+		return nil
+	}
+
+	err := ti.server.Stop()
+	untrackDataDir(ti.server.Config.DataDir)
+	ti.server = nil
+	ti.client = nil
+
+	if err != nil {
+		return fmt.Errorf("error while stopping instance %s: %s", ti.name, err)
+	}
+	return nil
+}
+
 func (ti *TestInstance) Name() string {
 	return ti.name
 }
 
+// ID returns the consul node ID of this instance.  This remains stable across restarts of the underlying server, and
+// remains available once the instance has stopped.
+func (ti *TestInstance) ID() string {
+	ti.m.Lock()
+	defer ti.m.Unlock()
+	if ti.conf == nil {
+		return ""
+	}
+	return ti.conf.NodeID
+}
+
+// Same reports whether other represents the same consul node as this instance
+func (ti *TestInstance) Same(other *TestInstance) bool {
+	if ti == nil || other == nil {
+		return ti == other
+	}
+	if ti == other {
+		return true
+	}
+	id := ti.ID()
+	return id != "" && id == other.ID()
+}
+
 func (ti *TestInstance) HTTPAddr() string {
 	ti.m.Lock()
 	defer ti.m.Unlock()
 	if ti.server == nil {
 		panic(fmt.Sprintf("Instance %s is defunct", ti.name))
 	}
-	return ti.server.HTTPAddr
+	return httpHostPort(ti.server.Config, ti.server.Config.Ports.HTTP)
 }
 
 func (ti *TestInstance) HTTPSAddr() string {
@@ -63,25 +339,42 @@ func (ti *TestInstance) HTTPSAddr() string {
 	if ti.server == nil {
 		panic(fmt.Sprintf("Instance %s is defunct", ti.name))
 	}
-	return ti.server.HTTPSAddr
+	return httpHostPort(ti.server.Config, ti.server.Config.Ports.HTTPS)
 }
 
+// LANAddr returns the serf LAN address of this instance.  Unlike the testutil package, which assumes IPv4 loopback,
+// this is built from the configured bind address, so an IPv6 bind such as "::1" yields "[::1]:port".
 func (ti *TestInstance) LANAddr() string {
 	ti.m.Lock()
 	defer ti.m.Unlock()
 	if ti.server == nil {
 		panic(fmt.Sprintf("Instance %s is defunct", ti.name))
 	}
-	return ti.server.LANAddr
+	return net.JoinHostPort(ti.server.Config.Bind, strconv.Itoa(ti.server.Config.Ports.SerfLan))
 }
 
+// WANAddr returns the serf WAN address of this instance, built from the configured bind address as LANAddr is
 func (ti *TestInstance) WANAddr() string {
 	ti.m.Lock()
 	defer ti.m.Unlock()
 	if ti.server == nil {
 		panic(fmt.Sprintf("Instance %s is defunct", ti.name))
 	}
-	return ti.server.WANAddr
+	return net.JoinHostPort(ti.server.Config.Bind, strconv.Itoa(ti.server.Config.Ports.SerfWan))
+}
+
+// Addr returns the scheme and host:port callers should use to reach this instance's HTTP API, preferring HTTPS when
+// TLS is configured.
+func (ti *TestInstance) Addr() (scheme, hostport string, err error) {
+	ti.m.Lock()
+	defer ti.m.Unlock()
+	if ti.server == nil {
+		return "", "", ti.defunctErr()
+	}
+	if ti.server.Config.CertFile != "" && ti.server.Config.KeyFile != "" {
+		return "https", httpHostPort(ti.server.Config, ti.server.Config.Ports.HTTPS), nil
+	}
+	return "http", httpHostPort(ti.server.Config, ti.server.Config.Ports.HTTP), nil
 }
 
 func (ti *TestInstance) HTTPClient() *http.Client {
@@ -112,23 +405,221 @@ func (ti *TestInstance) Config() *testutil.TestServerConfig {
 	return ti.server.Config
 }
 
+// configCopy returns a copy of the underlying test server config, taken under the instance lock
+func (ti *TestInstance) configCopy() (testutil.TestServerConfig, error) {
+	ti.m.Lock()
+	defer ti.m.Unlock()
+	if ti.server == nil {
+		return testutil.TestServerConfig{}, ti.defunctErr()
+	}
+	return *ti.server.Config, nil
+}
+
+// Bootstrap returns whether the instance was configured to bootstrap its cluster
+func (ti *TestInstance) Bootstrap() (bool, error) {
+	conf, err := ti.configCopy()
+	return conf.Bootstrap, err
+}
+
+// Server returns whether the instance was configured to run in server mode
+func (ti *TestInstance) Server() (bool, error) {
+	conf, err := ti.configCopy()
+	return conf.Server, err
+}
+
+// LogLevel returns the log level the instance was configured with
+func (ti *TestInstance) LogLevel() (string, error) {
+	conf, err := ti.configCopy()
+	return conf.LogLevel, err
+}
+
+// BootstrapExpect returns the number of servers the instance waits for before bootstrapping, 0 if disabled.  As the
+// testutil config struct does not carry the setting, it is read from the running agent, reflecting any set via
+// WithArgs or WithExtraConfig.
+func (ti *TestInstance) BootstrapExpect() (int, error) {
+	client, ok := ti.apiClient()
+	if !ok {
+		return 0, ti.defunctErr()
+	}
+	self, err := client.Agent().Self()
+	if err != nil {
+		return 0, err
+	}
+	expect, _ := self["DebugConfig"]["BootstrapExpect"].(float64)
+	return int(expect), nil
+}
+
+// ConfigJSON returns the underlying test server config marshalled to JSON.  Marshalling happens under the instance
+// lock, so unlike Config the result is safe to use without risk of racing against or mutating internal state.
+func (ti *TestInstance) ConfigJSON() (json.RawMessage, error) {
+	ti.m.Lock()
+	defer ti.m.Unlock()
+	if ti.server == nil {
+		return nil, ti.defunctErr()
+	}
+	b, err := json.Marshal(ti.server.Config)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(b), nil
+}
+
+// WriteConfigFile writes the underlying test server config as JSON to path, in a form consul accepts via -config-file,
+// so that an equivalent agent may be started by hand
+func (ti *TestInstance) WriteConfigFile(path string) error {
+	b, err := ti.ConfigJSON()
+	if err != nil {
+		return err
+	}
+	buf := new(bytes.Buffer)
+	if err = json.Indent(buf, b, "", "  "); err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("unable to write config of instance \"%s\" to \"%s\": %s", ti.name, path, err)
+	}
+	return nil
+}
+
+// ReloadConfig applies mutate to a copy of the instance's config, writes the result over the config file testutil
+// started the agent with, and has the agent reload it.  Only settings consul can reload at runtime, such as the log
+// level, take effect.  Others are retained and apply once the instance is restarted, for example via
+// TestCluster.RestartInstance.
+func (ti *TestInstance) ReloadConfig(mutate func(*testutil.TestServerConfig)) error {
+	ti.m.Lock()
+	defer ti.m.Unlock()
+	if ti.server == nil {
+		return ti.defunctErr()
+	}
+
+	// testutil does not expose where it wrote the config, it always passes that file first
+	_, args, err := findProcess(ti.server.Config.DataDir)
+	if err != nil {
+		return fmt.Errorf("unable to locate process of instance \"%s\": %s", ti.name, err)
+	}
+	configFile, ok := argValue(args, "-config-file")
+	if !ok {
+		return fmt.Errorf("instance \"%s\" was not started with a config file", ti.name)
+	}
+
+	updated := *ti.conf
+	mutate(&updated)
+
+	b, err := json.Marshal(&updated)
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(configFile, b, 0644); err != nil {
+		return fmt.Errorf("unable to rewrite config of instance \"%s\": %s", ti.name, err)
+	}
+	*ti.conf = updated
+
+	if err = ti.client.Agent().Reload(); err != nil {
+		return fmt.Errorf("instance \"%s\" failed to reload its config: %s", ti.name, err)
+	}
+	return nil
+}
+
 // Stop attempts to stop the underlying test server and nils about both the server and the client.  This instance
 // is considered defunct after this action, and all further interaction will cause a panic.
 func (ti *TestInstance) Stop() error {
+	return ti.stop(false)
+}
+
+// stop stops the underlying test server, cleaning up its files unless they are to be retained due to failure.  The
+// stop itself failing counts as a failure.
+func (ti *TestInstance) stop(failed bool) error {
+	ti.m.Lock()
+	defer ti.m.Unlock()
+
+	err := ti.stopServer()
+	ti.cleanupDir(failed || err != nil)
+
+	return err
+}
+
+// Kill simulates a hard crash by sending SIGKILL to the consul process, giving it no chance to shut down gracefully or
+// leave the cluster.  Peers will see the node as failed rather than left.  Unlike Stop, no attempt is made at a clean
+// shutdown, though the instance is likewise defunct afterwards and its files are removed.
+func (ti *TestInstance) Kill() error {
 	ti.m.Lock()
 	defer ti.m.Unlock()
+	if ti.server == nil {
+		return ti.defunctErr()
+	}
+
+	pid, err := findPID(ti.server.Config.DataDir)
+	if err == nil {
+		err = killPID(pid)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to kill instance \"%s\": %s", ti.name, err)
+	}
+
+	// reaps the killed process, its error is expected
+	ti.stopServer()
+	ti.removeDir()
+
+	return nil
+}
+
+// StopTimeout attempts to gracefully stop the underlying test server as Stop does, but if that has not completed
+// within d the consul process is killed outright.  The instance is defunct afterwards either way.  If the process had
+// to be killed, the returned error will wrap ErrStopTimeout.
+func (ti *TestInstance) StopTimeout(d time.Duration) (err error) {
+	ti.m.Lock()
+	defer ti.m.Unlock()
+	defer func() {
+		ti.cleanupDir(err != nil)
+	}()
 	if ti.server == nil {
 		return nil
 	}
 
-	err := ti.server.Stop()
+	server := ti.server
 	ti.server = nil
 	ti.client = nil
 
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Stop()
+	}()
+
+	select {
+	case err := <-done:
+		untrackDataDir(server.Config.DataDir)
+		if err != nil {
+			return fmt.Errorf("error while stopping instance %s: %s", ti.name, err)
+		}
+		return nil
+	case <-time.After(d):
+	}
+
+	var pid int
+	pid, err = findPID(server.Config.DataDir)
+	if err == nil {
+		err = killPID(pid)
+	}
 	if err != nil {
-		return fmt.Errorf("error while stopping instance %s: %s", ti.name, err)
+		return fmt.Errorf("instance \"%s\" did not stop within %s and could not be killed: %s: %w", ti.name, d, err, ErrStopTimeout)
 	}
-	return nil
+
+	// the graceful stop will return once the killed process has been reaped
+	<-done
+	untrackDataDir(server.Config.DataDir)
+
+	return fmt.Errorf("instance \"%s\" did not stop within %s and was killed: %w", ti.name, d, ErrStopTimeout)
+}
+
+// DataDir returns the data dir of this instance.  It remains available once the instance has stopped, so that the
+// location of data retained by WithRetainDataOnFailure may be found.
+func (ti *TestInstance) DataDir() string {
+	ti.m.Lock()
+	defer ti.m.Unlock()
+	if ti.conf == nil {
+		return ""
+	}
+	return ti.conf.DataDir
 }
 
 func (ti *TestInstance) Stopped() bool {
@@ -137,6 +628,53 @@ func (ti *TestInstance) Stopped() bool {
 	return ti.server == nil
 }
 
+// defunctErr wraps ErrInstanceDefunct with the name of this instance
+func (ti *TestInstance) defunctErr() error {
+	return fmt.Errorf("instance \"%s\": %w", ti.name, ErrInstanceDefunct)
+}
+
+// apiClient returns the api client without panicking, reporting false if the instance is defunct
+func (ti *TestInstance) apiClient() (*api.Client, bool) {
+	ti.m.Lock()
+	defer ti.m.Unlock()
+	if ti.server == nil {
+		return nil, false
+	}
+	return ti.client, true
+}
+
+// raftAddr returns the address this instance is known by in the raft peer set, reporting false if the instance is
+// defunct
+func (ti *TestInstance) raftAddr() (string, bool) {
+	ti.m.Lock()
+	defer ti.m.Unlock()
+	if ti.server == nil {
+		return "", false
+	}
+	return net.JoinHostPort(ti.server.Config.Bind, strconv.Itoa(ti.server.Config.Ports.Server)), true
+}
+
+// wanAddr returns the serf WAN address of this instance without panicking, reporting false if the instance is defunct
+func (ti *TestInstance) wanAddr() (string, bool) {
+	ti.m.Lock()
+	defer ti.m.Unlock()
+	if ti.server == nil {
+		return "", false
+	}
+	return net.JoinHostPort(ti.server.Config.Bind, strconv.Itoa(ti.server.Config.Ports.SerfWan)), true
+}
+
+// peerIdentity returns the node ID and address this instance is, or was, known by in the raft peer set.  Unlike
+// raftAddr this remains available once the instance has stopped.
+func (ti *TestInstance) peerIdentity() (id, addr string) {
+	ti.m.Lock()
+	defer ti.m.Unlock()
+	if ti.conf == nil {
+		return "", ""
+	}
+	return ti.conf.NodeID, net.JoinHostPort(ti.conf.Bind, strconv.Itoa(ti.conf.Ports.Server))
+}
+
 type (
 	// ClusterServerConfigCallback is a small wrapper around testutil.ServerConfigCallback that adds scope
 	ClusterServerConfigCallback = func(name string, num uint8, conf *testutil.TestServerConfig)
@@ -150,12 +688,74 @@ type (
 		size      uint8
 		instances []*TestInstance
 		stopped   bool
+
+		opts  []Option
+		hooks *lifecycleHooks
+		// pending holds lifecycle events raised while m is held, fired by unlock once it has been released
+		pending hookQueue
 	}
 )
 
+// ClusterInstanceName returns the name given to instance num of the named cluster
+func ClusterInstanceName(cluster string, num uint8) string {
+	return fmt.Sprintf("%s-%d", cluster, num)
+}
+
+// ChainCallbacks returns a callback which applies each of cbs to the config in turn, so later callbacks see and may
+// override the changes of earlier ones.  nil callbacks are skipped.
+func ChainCallbacks(cbs ...testutil.ServerConfigCallback) testutil.ServerConfigCallback {
+	return func(conf *testutil.TestServerConfig) {
+		for _, cb := range cbs {
+			if cb != nil {
+				cb(conf)
+			}
+		}
+	}
+}
+
+// ChainClusterCallbacks is ChainCallbacks for cluster callbacks, each being passed the same name and member number
+func ChainClusterCallbacks(cbs ...ClusterServerConfigCallback) ClusterServerConfigCallback {
+	return func(name string, num uint8, conf *testutil.TestServerConfig) {
+		for _, cb := range cbs {
+			if cb != nil {
+				cb(name, num, conf)
+			}
+		}
+	}
+}
+
+// memberCallback scopes a cluster callback to member num, applying any cluster-only options after it
+func memberCallback(name string, num uint8, cb ClusterServerConfigCallback, o *options) testutil.ServerConfigCallback {
+	return func(conf *testutil.TestServerConfig) {
+		cb(name, num, conf)
+		if o.nodeMeta != nil {
+			if conf.NodeMeta == nil {
+				conf.NodeMeta = make(map[string]string)
+			}
+			for k, v := range o.nodeMeta(num) {
+				conf.NodeMeta[k] = v
+			}
+		}
+		if o.noBootstrap {
+			conf.Bootstrap = false
+		}
+	}
+}
+
+// memberOptions returns opts with any cluster-only options which resolve per member resolved for member num
+func memberOptions(num uint8, opts []Option, o *options) []Option {
+	if o.nodeIDs == nil {
+		return opts
+	}
+	return append(opts[:len(opts):len(opts)], WithNodeID(o.nodeIDs(num)))
+}
+
+// DefaultClusterServerConfigCallback is used by NewTestCluster when no callback is provided.  Instance 0 bootstraps the
+// cluster.  Update checks against checkpoint are disabled so tests make no outbound calls, use WithCheckpoint should
+// they be wanted.
 var DefaultClusterServerConfigCallback ClusterServerConfigCallback = func(name string, num uint8, conf *testutil.TestServerConfig) {
 	conf.Performance.RaftMultiplier = 1
-	conf.DisableCheckpoint = false
+	conf.DisableCheckpoint = true
 	if num == 0 {
 		conf.Bootstrap = true
 	} else {
@@ -163,10 +763,13 @@ var DefaultClusterServerConfigCallback ClusterServerConfigCallback = func(name s
 	}
 }
 
-// NewTestCluster will attempt to spin up a cluster of consul test servers of the specified size
-func NewTestCluster(name string, size uint8, cb ClusterServerConfigCallback) (*TestCluster, error) {
-	var err error
-
+// NewTestCluster will attempt to spin up a cluster of consul test servers of the specified size.  Any options provided
+// are retained and also applied to instances added by later calls to Grow.  Should any instance fail to start, those
+// already started are stopped and a *ClusterStartError is returned.  Once all instances are up, it waits for a leader
+// to be elected, tearing the cluster down and returning an error wrapping ErrBootstrapTimeout should none be within
+// the bootstrap timeout, DefaultLeaderTimeout unless set by WithBootstrapTimeout.  Clusters created WithNoBootstrap
+// are returned without waiting.
+func NewTestCluster(name string, size uint8, cb ClusterServerConfigCallback, opts ...Option) (*TestCluster, error) {
 	if size == 0 {
 		return nil, errors.New("size must be at least 1")
 	}
@@ -175,35 +778,120 @@ func NewTestCluster(name string, size uint8, cb ClusterServerConfigCallback) (*T
 		m:         new(sync.Mutex),
 		name:      name,
 		size:      size,
-		instances: make([]*TestInstance, 1, math.MaxUint8),
+		instances: make([]*TestInstance, 0, size),
+		opts:      opts,
 	}
 
 	if cb == nil {
 		cb = DefaultClusterServerConfigCallback
 	}
 
-	cl.instances[0], err = NewTestInstance(fmt.Sprintf("%s-%d", name, 0), func(conf *testutil.TestServerConfig) {
-		cb(name, 0, conf)
-	})
+	o := buildOptions(opts)
+	if err := o.validateCluster(); err != nil {
+		return nil, fmt.Errorf("invalid options for cluster \"%s\": %s", name, err)
+	}
+
+	instance, err := NewTestInstance(ClusterInstanceName(name, 0), memberCallback(name, 0, cb, o), memberOptions(0, opts, o)...)
 	if err != nil {
-		return nil, err
+		return nil, &ClusterStartError{Name: name, Size: size, Started: 0, Failed: 0, Err: err}
+	}
+
+	cl.instances = append(cl.instances, instance)
+
+	if o.progress != nil {
+		o.progress(1, int(size))
+	}
+
+	cl.m.Lock()
+	defer cl.unlock()
+
+	if size > 1 {
+		err = cl.grow(size-1, cb, opts, 1, int(size))
+		if err != nil {
+			// instances are added in order, so the one that failed is always the next in line
+			started := len(cl.instances)
+			for i := started; i > 0; i-- {
+				cl.instances[i-1].stop(true)
+			}
+			return nil, &ClusterStartError{Name: name, Size: size, Started: started, Failed: uint8(started), Err: err}
+		}
 	}
 
-	if size == 1 {
+	if o.noBootstrap {
 		return cl, nil
 	}
 
-	err = cl.Grow(size-1, cb)
-	if err != nil {
-		ul := len(cl.instances)
-		if ul > 0 {
-			for u := ul - 1; u >= 0; u-- {
-				cl.instances[u].Stop()
+	timeout := o.bootstrapTimeout
+	if timeout <= 0 {
+		timeout = DefaultLeaderTimeout
+	}
+	if err = cl.waitForLeader(timeout); err != nil {
+		cl.stop()
+		return nil, fmt.Errorf("%s: %w", err, ErrBootstrapTimeout)
+	}
+
+	return cl, nil
+}
+
+// NewTestClusterBestEffort will attempt to start size instances, keeping those which come up rather than tearing the
+// cluster down at the first failure.  Members are numbered by the order in which they started, so the cluster holds
+// only live instances and the first of them to start bootstraps it.  Should any attempt fail, the returned error is a
+// *MultiErr describing each failure alongside the cluster.  No leader is waited for, and as the cluster may be short
+// of members it may well not have quorum.  Should no instance start at all, the cluster returned is nil.
+func NewTestClusterBestEffort(name string, size uint8, cb ClusterServerConfigCallback, opts ...Option) (*TestCluster, error) {
+	if size == 0 {
+		return nil, errors.New("size must be at least 1")
+	}
+
+	cl := &TestCluster{
+		m:         new(sync.Mutex),
+		name:      name,
+		size:      size,
+		instances: make([]*TestInstance, 0, size),
+		opts:      opts,
+	}
+
+	if cb == nil {
+		cb = DefaultClusterServerConfigCallback
+	}
+
+	o := buildOptions(opts)
+	if err := o.validateCluster(); err != nil {
+		return nil, fmt.Errorf("invalid options for cluster \"%s\": %s", name, err)
+	}
+	errs := NewMultiErr()
+
+	cl.m.Lock()
+	defer cl.m.Unlock()
+
+	for attempt := 0; attempt < int(size); attempt++ {
+		num := uint8(len(cl.instances))
+
+		instance, err := NewTestInstance(ClusterInstanceName(name, num), memberCallback(name, num, cb, o), memberOptions(num, opts, o)...)
+		if err != nil {
+			errs.Add(fmt.Errorf("attempt %d of %d: %w", attempt+1, size, err))
+			continue
+		}
+		if num > 0 {
+			if err = cl.join(instance, o.wanJoin); err != nil {
+				instance.stop(true)
+				errs.Add(fmt.Errorf("attempt %d of %d: %w", attempt+1, size, &InstanceStartError{Name: instance.Name(), Phase: PhaseJoin, Err: err}))
+				continue
 			}
 		}
-		return nil, err
+		cl.instances = append(cl.instances, instance)
+
+		if o.progress != nil {
+			o.progress(len(cl.instances), int(size))
+		}
 	}
 
+	if len(cl.instances) == 0 {
+		return nil, errs
+	}
+	if errs.Size() > 0 {
+		return cl, errs
+	}
 	return cl, nil
 }
 
@@ -223,98 +911,329 @@ func (cl *TestCluster) Stopped() bool {
 	return cl.stopped
 }
 
-// Stop will attempt to stop the entire cluster.  Once called, the cluster is considered defunct and all further
-// interactions will cause a panic
+// Stop will attempt to stop the entire cluster.  Once called, the cluster is considered defunct.  Further calls to
+// methods returning an error will return one, while the remainder will panic.
 func (cl *TestCluster) Stop() error {
 	cl.m.Lock()
-	defer cl.m.Unlock()
+	defer cl.unlock()
 	if cl.stopped {
 		return nil
 	}
 	return cl.stop()
 }
 
-func (cl *TestCluster) stop() error {
-	l := len(cl.instances)
-	if l == 0 {
-		return nil
+// stop stops every member of the cluster, marking it defunct.  Caller must hold lock.
+func (cl *TestCluster) stop() error {
+	cl.stopped = true
+
+	l := len(cl.instances)
+	if l == 0 {
+		return nil
+	}
+
+	var err error = NewMultiErr()
+	for i := l - 1; i >= 0; i-- {
+		err.(*MultiErr).Add(cl.stopInstance(cl.instances[i]))
+	}
+
+	if err.(*MultiErr).Size() > 0 {
+		return err
+	}
+	return nil
+}
+
+// stopInstance stops a member of this cluster, queuing a lifecycle event if it was live.  Caller must hold lock.
+func (cl *TestCluster) stopInstance(instance *TestInstance) error {
+	if instance.Stopped() {
+		// may have been stopped in place, leaving its files behind
+		return instance.Stop()
+	}
+	err := instance.Stop()
+	cl.pending.stopped(instance.Name())
+	return err
+}
+
+// liveInstances returns the members of this cluster which have not been stopped.  Caller must hold lock.
+func (cl *TestCluster) liveInstances() []*TestInstance {
+	live := make([]*TestInstance, 0, len(cl.instances))
+	for _, instance := range cl.instances {
+		if !instance.Stopped() {
+			live = append(live, instance)
+		}
+	}
+	return live
+}
+
+// instanceList returns a copy of the members of this cluster, including any that have been stopped
+func (cl *TestCluster) instanceList() []*TestInstance {
+	cl.m.Lock()
+	defer cl.m.Unlock()
+	return append(make([]*TestInstance, 0, len(cl.instances)), cl.instances...)
+}
+
+// unlock releases the lock, then notifies the lifecycle hooks of any events raised while it was held
+func (cl *TestCluster) unlock() {
+	events := cl.pending.take()
+	hooks := cl.hooks
+	cl.m.Unlock()
+	hooks.fire(events)
+}
+
+// setHooks attaches the lifecycle hooks of the manager this cluster is registered with
+func (cl *TestCluster) setHooks(hooks *lifecycleHooks) {
+	cl.m.Lock()
+	defer cl.m.Unlock()
+	cl.hooks = hooks
+}
+
+// Instance will attempt to return a single instance from this cluster
+func (cl *TestCluster) Instance(num uint8) *TestInstance {
+	cl.m.Lock()
+	defer cl.m.Unlock()
+	if cl.stopped {
+		panic(fmt.Sprintf("Cluster %s is defunct", cl.name))
+	}
+	return cl.instances[num]
+}
+
+// Client returns the api client of instance num, or an error if the cluster or that instance has been stopped or num
+// is out of range
+func (cl *TestCluster) Client(num uint8) (*api.Client, error) {
+	cl.m.Lock()
+	defer cl.m.Unlock()
+	if cl.stopped {
+		return nil, fmt.Errorf("cluster \"%s\" is defunct", cl.name)
+	}
+	if int(num) >= len(cl.instances) {
+		return nil, fmt.Errorf("cluster \"%s\" has no instance %d", cl.name, num)
+	}
+	client, ok := cl.instances[num].apiClient()
+	if !ok {
+		return nil, cl.instances[num].defunctErr()
+	}
+	return client, nil
+}
+
+// Grow will attempt to add n number of test instances to the cluster.  Options provided here are applied after those
+// the cluster was created with.
+func (cl *TestCluster) Grow(n uint8, cb ClusterServerConfigCallback, opts ...Option) error {
+	_, err := cl.GrowResult(n, cb, opts...)
+	return err
+}
+
+// GrowResult is Grow, additionally returning the instances added to the cluster.  Should growing fail part way, those
+// added before the failure are returned alongside the error.
+func (cl *TestCluster) GrowResult(n uint8, cb ClusterServerConfigCallback, opts ...Option) ([]*TestInstance, error) {
+	cl.m.Lock()
+	defer cl.unlock()
+	if cl.stopped {
+		return nil, fmt.Errorf("cluster \"%s\" is defunct", cl.name)
+	}
+
+	merged := make([]Option, 0, len(cl.opts)+len(opts))
+	merged = append(append(merged, cl.opts...), opts...)
+	if err := buildOptions(merged).validateCluster(); err != nil {
+		return nil, fmt.Errorf("invalid options for cluster \"%s\": %s", cl.name, err)
+	}
+
+	current := len(cl.instances)
+	err := cl.grow(n, cb, merged, 0, int(n))
+	added := append(make([]*TestInstance, 0, len(cl.instances)-current), cl.instances[current:]...)
+
+	return added, err
+}
+
+// grow adds n number of test instances to the cluster, reporting progress as done out of total.  Caller must hold
+// lock.
+func (cl *TestCluster) grow(n uint8, cb ClusterServerConfigCallback, opts []Option, done, total int) error {
+	o := buildOptions(opts)
+
+	current := len(cl.instances)
+
+	if (current + int(n)) > math.MaxUint8 {
+		return fmt.Errorf("\"%s\" is already \"%d\" instances long, cannot grow by \"%d\" as it would breach the max allowed cluster instance size of \"%d\"", cl.name, current, n, math.MaxUint8)
+	}
+
+	for i := uint8(0); i < n; i++ {
+		offset := uint8(current) + i
+
+		instance, err := NewTestInstance(ClusterInstanceName(cl.name, offset), memberCallback(cl.name, offset, cb, o), memberOptions(offset, opts, o)...)
+		if err != nil {
+			return fmt.Errorf("unable to grow \"%s\", instance \"%d\" creation failed: %w", cl.name, offset, err)
+		}
+		err = cl.join(instance, o.wanJoin)
+		if err != nil {
+			instance.stop(true)
+			return fmt.Errorf("unable to grow \"%s\", instance \"%d\" failed to join: %w", cl.name, offset, &InstanceStartError{Name: instance.Name(), Phase: PhaseJoin, Err: err})
+		}
+		cl.instances = append(cl.instances, instance)
+		cl.pending.started(instance.Name())
+
+		if o.progress != nil {
+			o.progress(done+int(i)+1, total)
+		}
+	}
+
+	return nil
+}
+
+// GrowNonVoting will attempt to add n number of non-voting servers to the cluster.  These servers receive replicated
+// state but do not take part in quorum, making them suitable for testing read-scaling topologies.  Non-voting servers
+// are a Consul Enterprise feature, and the instances will fail to start against an OSS binary.
+func (cl *TestCluster) GrowNonVoting(n uint8, cb ClusterServerConfigCallback, opts ...Option) error {
+	if cb == nil {
+		cb = DefaultClusterServerConfigCallback
+	}
+	return cl.Grow(n, func(name string, num uint8, conf *testutil.TestServerConfig) {
+		cb(name, num, conf)
+		conf.Args = append(conf.Args, "-non-voting-server")
+	}, opts...)
+}
+
+// join has a new instance join the cluster via the current leader, or via any live member should there be no leader.
+// If wan is true the instance joins the WAN pool rather than the LAN pool.  Caller must hold lock.
+func (cl *TestCluster) join(instance *TestInstance, wan bool) error {
+	client, err := cl.leaderClient()
+	if err != nil {
+		for _, member := range cl.instances {
+			if c, ok := member.apiClient(); ok {
+				client = c
+				break
+			}
+		}
+	}
+	if client == nil {
+		return fmt.Errorf("cluster \"%s\" has no live instances to join through", cl.name)
+	}
+	if wan {
+		return client.Agent().Join(instance.WANAddr(), true)
+	}
+	return client.Agent().Join(instance.LANAddr(), false)
+}
+
+// RestartAll will stop every live instance in the cluster and then start them back up, bootstrap node first, waiting
+// for a leader to be elected.  Each instance resumes from its preserved data dir, allowing tests of cold-start
+// recovery from persisted raft state.  Members already stopped in place or killed are left stopped.
+func (cl *TestCluster) RestartAll() error {
+	cl.m.Lock()
+	defer cl.unlock()
+	if cl.stopped {
+		return fmt.Errorf("cluster \"%s\" is defunct", cl.name)
 	}
 
 	var err error = NewMultiErr()
-	for i := l - 1; i >= 0; i-- {
-		err.(*MultiErr).Add(cl.instances[i].Stop())
-	}
 
-	cl.stopped = true
+	// members stopped in place or killed are left as they are
+	live := cl.liveInstances()
+	for i := len(live) - 1; i >= 0; i-- {
+		err.(*MultiErr).Add(live[i].halt())
+		cl.pending.stopped(live[i].Name())
+	}
+	for _, instance := range live {
+		if rerr := instance.resume(); rerr != nil {
+			err.(*MultiErr).Add(rerr)
+		} else {
+			cl.pending.started(instance.Name())
+		}
+	}
 
 	if err.(*MultiErr).Size() > 0 {
 		return err
 	}
-	return nil
+
+	return cl.waitForLeader(DefaultLeaderTimeout)
 }
 
-// Instance will attempt to return a single instance from this cluster
-func (cl *TestCluster) Instance(num uint8) *TestInstance {
+// StopInstance will stop instance num in place, as though its host were rebooting.  Unlike Shrink, the instance keeps
+// its slot and data dir, so Size is unchanged while LiveSize drops, and it may be brought back with RestartInstance.
+func (cl *TestCluster) StopInstance(num uint8) error {
 	cl.m.Lock()
-	defer cl.m.Unlock()
+	defer cl.unlock()
 	if cl.stopped {
-		panic(fmt.Sprintf("Cluster %s is defunct", cl.name))
+		return fmt.Errorf("cluster \"%s\" is defunct", cl.name)
 	}
-	return cl.instances[num]
+	if int(num) >= len(cl.instances) {
+		return fmt.Errorf("cluster \"%s\" has no instance %d", cl.name, num)
+	}
+
+	instance := cl.instances[num]
+	if instance.Stopped() {
+		return nil
+	}
+	err := instance.halt()
+	cl.pending.stopped(instance.Name())
+	return err
 }
 
-// Grow will attempt to add n number of test instances to the cluster
-func (cl *TestCluster) Grow(n uint8, cb ClusterServerConfigCallback) error {
+// RestartInstance will start instance num back up from its preserved data dir after a call to StopInstance, and have
+// it rejoin the cluster.  Restarting a live instance is a no-op.
+func (cl *TestCluster) RestartInstance(num uint8) error {
 	cl.m.Lock()
-	defer cl.m.Unlock()
+	defer cl.unlock()
 	if cl.stopped {
-		panic(fmt.Sprintf("Cluster %s is defunct", cl.name))
+		return fmt.Errorf("cluster \"%s\" is defunct", cl.name)
 	}
-
-	current := len(cl.instances)
-
-	if (current + int(n)) > math.MaxUint8 {
-		return fmt.Errorf("\"%s\" is already \"%d\" instances long, cannot grow by \"%d\" as it would breach the max allowed cluster instance size of \"%d\"", cl.name, current, n, math.MaxUint8)
+	if int(num) >= len(cl.instances) {
+		return fmt.Errorf("cluster \"%s\" has no instance %d", cl.name, num)
 	}
 
-	for i := uint8(0); i < n; i++ {
-		offset := uint8(current) + i
-
-		instance, err := NewTestInstance(fmt.Sprintf("%s-%d", cl.name, offset), func(conf *testutil.TestServerConfig) {
-			cb(cl.name, offset, conf)
-		})
-		if err != nil {
-			return fmt.Errorf("unable to grow \"%s\", instance \"%d\" creation failed: %s", cl.name, offset, err)
-		}
-		err = cl.instances[0].APIClient().Agent().Join(instance.LANAddr(), false)
-		if err != nil {
-			instance.Stop()
-			return fmt.Errorf("unable to grow \"%s\", instance \"%d\" failed to join: %s", cl.name, offset, err)
-		}
-		cl.instances = append(cl.instances, instance)
+	instance := cl.instances[num]
+	if !instance.Stopped() {
+		return nil
+	}
+	if err := instance.resume(); err != nil {
+		return err
 	}
+	cl.pending.started(instance.Name())
 
+	if err := cl.join(instance, buildOptions(cl.opts).wanJoin); err != nil {
+		return fmt.Errorf("instance \"%s\" restarted but failed to rejoin \"%s\": %s", instance.Name(), cl.name, err)
+	}
 	return nil
 }
 
-// Shrink will reduce the # of servers in the cluster, starting with the most recently added.
-func (cl *TestCluster) Shrink(n uint8) error {
+// LiveSize returns the number of members of the cluster which are currently running.  This differs from Size while any
+// instance is stopped in place.
+func (cl *TestCluster) LiveSize() int {
 	cl.m.Lock()
 	defer cl.m.Unlock()
+	return len(cl.liveInstances())
+}
+
+// Shrink will reduce the # of servers in the cluster, starting with the most recently added.  Shrinking by the size of
+// the cluster or more will stop it entirely.
+func (cl *TestCluster) Shrink(n uint8) error {
+	cl.m.Lock()
+	defer cl.unlock()
+	if cl.stopped {
+		return fmt.Errorf("cluster \"%s\" is defunct", cl.name)
+	}
+	return cl.shrink(n)
+}
 
+// shrink stops the n most recently added instances, in parallel up to the cluster's max concurrency.  Caller must hold
+// lock.
+func (cl *TestCluster) shrink(n uint8) error {
 	l := len(cl.instances)
-	if n > uint8(l) {
+	if int(n) >= l {
 		return cl.stop()
 	}
 
 	var err error = NewMultiErr()
 
-	diff := uint8(l) - n
-	for i := uint8(l - 1); i > diff; i-- {
-		err.(*MultiErr).Add(cl.instances[i].Stop())
+	limit := runtime.GOMAXPROCS(0)
+	if concurrency := buildOptions(cl.opts).concurrency; concurrency != nil {
+		limit = concurrency()
 	}
 
-	cl.instances = cl.instances[0:diff]
+	// the slice is only truncated once every removed instance has stopped, the lock keeping it stable until then
+	keep := l - int(n)
+	removed := cl.instances[keep:]
+	runParallel(limit, len(removed), func(i int) {
+		err.(*MultiErr).Add(cl.stopInstance(removed[i]))
+	})
+
+	cl.instances = cl.instances[0:keep]
 
 	if err.(*MultiErr).Size() > 0 {
 		return err
@@ -322,6 +1241,43 @@ func (cl *TestCluster) Shrink(n uint8) error {
 	return nil
 }
 
+// ExpandToSize will grow or shrink the cluster so that it has exactly target live instances.  Members stopped in place
+// or killed are not counted, and are removed along with live ones when shrinking should they be among the most
+// recently added.  A nil cb uses DefaultClusterServerConfigCallback.
+func (cl *TestCluster) ExpandToSize(target uint8, cb ClusterServerConfigCallback) error {
+	if target == 0 {
+		return errors.New("target must be at least 1")
+	}
+	if cb == nil {
+		cb = DefaultClusterServerConfigCallback
+	}
+
+	cl.m.Lock()
+	defer cl.unlock()
+	if cl.stopped {
+		return fmt.Errorf("cluster \"%s\" is defunct", cl.name)
+	}
+
+	live := len(cl.liveInstances())
+
+	if int(target) > live {
+		n := target - uint8(live)
+		return cl.grow(n, cb, cl.opts, 0, int(n))
+	} else if int(target) < live {
+		// remove trailing slots until enough live members are among them
+		excess, n := live-int(target), 0
+		for i := len(cl.instances) - 1; excess > 0; i-- {
+			if !cl.instances[i].Stopped() {
+				excess--
+			}
+			n++
+		}
+		return cl.shrink(uint8(n))
+	}
+
+	return nil
+}
+
 type (
 	Instances map[string]*TestInstance
 	Clusters  map[string]*TestCluster
@@ -330,6 +1286,28 @@ type (
 		m         sync.Mutex
 		instances Instances
 		clusters  Clusters
+		hooks     *lifecycleHooks
+
+		// stopping counts instances and clusters removed from the maps whose stop has yet to complete
+		stopping int
+		cond     *sync.Cond
+
+		// maxConcurrency caps the number of instances or clusters batch operations act on at once.  It is accessed
+		// atomically as clusters read it while holding their own lock.
+		maxConcurrency int32
+
+		// nameGen and nameSeq produce names for instances and clusters created without one
+		nameGen func(kind string, seq uint64) string
+		nameSeq uint64
+
+		// supervisors holds a channel per auto restarted instance, closed to end its supervision
+		supervisors map[string]chan struct{}
+
+		// startM guards startInterval and lastStart, which pace instance startups.  It is held while waiting so that
+		// waiting startups are released one at a time.
+		startM        sync.Mutex
+		startInterval time.Duration
+		lastStart     time.Time
 	}
 )
 
@@ -337,45 +1315,281 @@ func NewAgentMan() *AgentMan {
 	am := &AgentMan{
 		instances: make(Instances),
 		clusters:  make(Clusters),
+		hooks:     new(lifecycleHooks),
+
+		supervisors: make(map[string]chan struct{}),
 	}
 
+	am.cond = sync.NewCond(&am.m)
+
 	return am
 }
 
-// NewInstance will attempt to create an un-clustered test instance
-func (am *AgentMan) NewInstance(name string, cb testutil.ServerConfigCallback) (*TestInstance, error) {
+// Wait blocks until every instance and cluster has been stopped and removed from this manager, whether by Stop or by
+// individual calls to StopInstance and StopCluster
+func (am *AgentMan) Wait() {
+	am.m.Lock()
+	defer am.m.Unlock()
+	for len(am.instances) > 0 || len(am.clusters) > 0 || am.stopping > 0 {
+		am.cond.Wait()
+	}
+}
+
+// doneStopping records that n previously removed instances or clusters have finished stopping
+func (am *AgentMan) doneStopping(n int) {
 	am.m.Lock()
 	defer am.m.Unlock()
+	am.stopping -= n
+	am.cond.Broadcast()
+}
+
+// SetMaxConcurrency caps how many instances or clusters batch operations such as GrowAll, ShrinkAll, and Stop will
+// act on in parallel, as well as how many instances clusters created via this manager stop at once when shrinking,
+// unless they were created with WithMaxConcurrency.  Values less than 1 restore the default of GOMAXPROCS.
+func (am *AgentMan) SetMaxConcurrency(n int) {
+	if n < 1 {
+		n = 0
+	} else if n > math.MaxInt32 {
+		n = math.MaxInt32
+	}
+	atomic.StoreInt32(&am.maxConcurrency, int32(n))
+}
+
+// concurrency returns the current cap on parallel batch work
+func (am *AgentMan) concurrency() int {
+	if n := atomic.LoadInt32(&am.maxConcurrency); n > 0 {
+		return int(n)
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// parallel calls fn for each i in [0, n), running no more than the manager's max concurrency at once, and returns
+// once all calls have completed
+func (am *AgentMan) parallel(n int, fn func(i int)) {
+	runParallel(am.concurrency(), n, fn)
+}
+
+// runParallel calls fn for each i in [0, n), running no more than limit at once, and returns once all calls have
+// completed
+func runParallel(limit, n int, fn func(i int)) {
+	sem := make(chan struct{}, limit)
+	wg := new(sync.WaitGroup)
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// SetStartInterval enforces a minimum interval between the startups of instances created via this manager, including
+// cluster members and instances added by Grow, smoothing the disk and CPU load of creating many in a tight loop.  This
+// paces startups rather than capping how many run at once as SetMaxConcurrency does.  Defaults to 0, no delay.
+func (am *AgentMan) SetStartInterval(d time.Duration) {
+	am.startM.Lock()
+	defer am.startM.Unlock()
+	am.startInterval = d
+}
+
+// paceStart blocks until the start interval has elapsed since the previous startup
+func (am *AgentMan) paceStart() {
+	am.startM.Lock()
+	defer am.startM.Unlock()
+	if wait := time.Until(am.lastStart.Add(am.startInterval)); wait > 0 {
+		time.Sleep(wait)
+	}
+	am.lastStart = time.Now()
+}
+
+// paced returns opts with startups gated by paceStart, and with this manager's max concurrency applied to clusters
+// unless opts provide their own
+func (am *AgentMan) paced(opts []Option) []Option {
+	paced := make([]Option, 0, len(opts)+2)
+	paced = append(paced, withConcurrency(am.concurrency))
+	return append(append(paced, opts...), withStartGate(am.paceStart))
+}
+
+// OnStart registers a handler to be called with the name of each instance once it has started, including members of
+// clusters created by this manager.  Handlers are called without the manager or cluster locks held, so may safely call
+// back into either.
+func (am *AgentMan) OnStart(fn func(name string)) {
+	am.hooks.addStart(fn)
+}
+
+// OnStop registers a handler to be called with the name of each instance once it has stopped, including members of
+// clusters created by this manager.  Handlers are called without the manager or cluster locks held, so may safely call
+// back into either.
+func (am *AgentMan) OnStop(fn func(name string)) {
+	am.hooks.addStop(fn)
+}
+
+// NewInstance will attempt to create an un-clustered test instance
+func (am *AgentMan) NewInstance(name string, cb testutil.ServerConfigCallback, opts ...Option) (*TestInstance, error) {
+	am.m.Lock()
 	if _, ok := am.instances[name]; ok {
-		return nil, fmt.Errorf("instance \"%s\" already exists", name)
+		am.m.Unlock()
+		return nil, fmt.Errorf("instance \"%s\": %w", name, ErrInstanceExists)
 	}
 
-	s, err := NewTestInstance(name, cb)
+	s, err := NewTestInstance(name, cb, am.paced(opts)...)
 	if err != nil {
+		am.m.Unlock()
 		return nil, err
 	}
 
 	am.instances[name] = s
+	am.m.Unlock()
+
+	am.hooks.started(name)
+
 	return s, nil
 }
 
+// GetOrCreateInstance will return the registered un-clustered test instance name, or attempt to create it should it not
+// exist.  A registered instance which has since been stopped is replaced with a new one.  The returned bool is true
+// when the instance was created by this call.
+func (am *AgentMan) GetOrCreateInstance(name string, cb testutil.ServerConfigCallback, opts ...Option) (*TestInstance, bool, error) {
+	am.m.Lock()
+	if s, ok := am.instances[name]; ok && !s.Stopped() {
+		am.m.Unlock()
+		return s, false, nil
+	}
+
+	s, err := NewTestInstance(name, cb, am.paced(opts)...)
+	if err != nil {
+		am.m.Unlock()
+		return nil, false, err
+	}
+
+	am.instances[name] = s
+	am.unsupervise(name)
+	am.m.Unlock()
+
+	am.hooks.started(name)
+
+	return s, true, nil
+}
+
 // NewCluster will attempt to create a clustered set of test instances
-func (am *AgentMan) NewCluster(name string, size uint8, cb ClusterServerConfigCallback) (*TestCluster, error) {
+func (am *AgentMan) NewCluster(name string, size uint8, cb ClusterServerConfigCallback, opts ...Option) (*TestCluster, error) {
 	am.m.Lock()
-	defer am.m.Unlock()
 	if _, ok := am.clusters[name]; ok {
-		return nil, fmt.Errorf("cluster \"%s\" already exists", name)
+		am.m.Unlock()
+		return nil, fmt.Errorf("cluster \"%s\": %w", name, ErrClusterExists)
 	}
 
-	cl, err := NewTestCluster(name, size, cb)
+	cl, err := NewTestCluster(name, size, cb, am.paced(opts)...)
 	if err != nil {
+		am.m.Unlock()
 		return nil, err
 	}
 
+	cl.setHooks(am.hooks)
+
 	am.clusters[name] = cl
+	am.m.Unlock()
+
+	for _, instance := range cl.instanceList() {
+		am.hooks.started(instance.Name())
+	}
+
 	return cl, nil
 }
 
+// SetNameGenerator replaces the function used to name instances and clusters created by NewInstanceAuto and
+// NewClusterAuto.  fn is passed "instance" or "cluster" as kind, along with a sequence number unique to this manager.
+// Should fn return a name already in use, it is called again with the next sequence number.  A nil fn restores the
+// default of "kind-seq".
+func (am *AgentMan) SetNameGenerator(fn func(kind string, seq uint64) string) {
+	am.m.Lock()
+	defer am.m.Unlock()
+	am.nameGen = fn
+}
+
+// autoName generates a name for kind which is not already taken
+func (am *AgentMan) autoName(kind string, taken func(name string) bool) (string, error) {
+	am.m.Lock()
+	defer am.m.Unlock()
+	gen := am.nameGen
+	if gen == nil {
+		gen = func(kind string, seq uint64) string {
+			return fmt.Sprintf("%s-%d", kind, seq)
+		}
+	}
+	for attempt := 0; attempt < 100; attempt++ {
+		am.nameSeq++
+		if name := gen(kind, am.nameSeq); !taken(name) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("unable to generate an unused %s name", kind)
+}
+
+// NewInstanceAuto will attempt to create an un-clustered test instance under a generated name, returned alongside it
+func (am *AgentMan) NewInstanceAuto(cb testutil.ServerConfigCallback, opts ...Option) (*TestInstance, string, error) {
+	name, err := am.autoName("instance", func(name string) bool {
+		_, ok := am.instances[name]
+		return ok
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	s, err := am.NewInstance(name, cb, opts...)
+	return s, name, err
+}
+
+// NewClusterAuto will attempt to create a clustered set of test instances under a generated name, returned alongside
+// it
+func (am *AgentMan) NewClusterAuto(size uint8, cb ClusterServerConfigCallback, opts ...Option) (*TestCluster, string, error) {
+	name, err := am.autoName("cluster", func(name string) bool {
+		_, ok := am.clusters[name]
+		return ok
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	cl, err := am.NewCluster(name, size, cb, opts...)
+	return cl, name, err
+}
+
+// ReplaceInstance will attempt to swap a registered non-clustered test instance with a freshly created one under the
+// same name.  The existing instance is only stopped once its replacement is up, and is left untouched if creation of
+// the replacement fails.
+func (am *AgentMan) ReplaceInstance(name string, cb testutil.ServerConfigCallback, opts ...Option) (*TestInstance, error) {
+	am.m.Lock()
+	old, ok := am.instances[name]
+	if !ok {
+		am.m.Unlock()
+		return nil, fmt.Errorf("instance \"%s\" does not exist", name)
+	}
+
+	s, err := NewTestInstance(name, cb, am.paced(opts)...)
+	if err != nil {
+		am.m.Unlock()
+		return nil, err
+	}
+
+	am.instances[name] = s
+	am.unsupervise(name)
+	am.m.Unlock()
+
+	am.hooks.started(name)
+
+	err = old.Stop()
+	am.hooks.stopped(name)
+	if err != nil {
+		return s, fmt.Errorf("instance \"%s\" replaced, but previous instance did not stop cleanly: %s", name, err)
+	}
+
+	return s, nil
+}
+
 // Instance will attempt to return a registered non-clustered test instance to you
 func (am *AgentMan) Instance(name string) (*TestInstance, bool) {
 	am.m.Lock()
@@ -392,66 +1606,197 @@ func (am *AgentMan) Cluster(name string) (*TestCluster, bool) {
 	return cl, ok
 }
 
-// StopInstance will attempt to stop a single instance, removing it from this manager
+// StopInstance will attempt to stop a single instance, removing it from this manager.  Stopping a name not registered
+// is a no-op, unless it names a member of a managed cluster, in which case an error wrapping ErrInstanceOwnedByCluster
+// is returned.
 func (am *AgentMan) StopInstance(name string) error {
 	am.m.Lock()
-	defer am.m.Unlock()
-
-	var err error
-
-	if s, ok := am.instances[name]; ok {
-		err = s.Stop()
+	s, ok := am.instances[name]
+	if ok {
 		delete(am.instances, name)
+		am.unsupervise(name)
+		am.stopping++
+	}
+	owner, owned := "", false
+	if !ok {
+		owner, owned = am.clusterOwning(name)
+	}
+	am.m.Unlock()
+
+	if owned {
+		return fmt.Errorf("unable to stop \"%s\", stop it via cluster \"%s\": %w", name, owner, ErrInstanceOwnedByCluster)
+	}
+	if !ok {
+		return nil
 	}
 
+	err := s.Stop()
+	am.doneStopping(1)
+	am.hooks.stopped(name)
+
 	return err
 }
 
+// clusterOwning returns the name of the managed cluster with a member named name, if any.  Caller must hold lock.
+func (am *AgentMan) clusterOwning(name string) (string, bool) {
+	for clusterName, cluster := range am.clusters {
+		for _, instance := range cluster.instanceList() {
+			if instance.Name() == name {
+				return clusterName, true
+			}
+		}
+	}
+	return "", false
+}
+
 // StopCluster will attempt to stop a single cluster, removing it from this manager
 func (am *AgentMan) StopCluster(name string) error {
 	am.m.Lock()
-	defer am.m.Unlock()
+	cl, ok := am.clusters[name]
+	if ok {
+		delete(am.clusters, name)
+		am.stopping++
+	}
+	am.m.Unlock()
+
+	if !ok {
+		return nil
+	}
 
-	var err error
+	defer am.doneStopping(1)
 
-	if cl, ok := am.clusters[name]; ok {
-		err = cl.Stop()
-		delete(am.clusters, name)
+	return cl.Stop()
+}
+
+// GrowAll will attempt to grow every registered cluster by n instances, skipping any that have been stopped.  Clusters
+// are grown in parallel, up to the manager's max concurrency.
+func (am *AgentMan) GrowAll(n uint8, cb ClusterServerConfigCallback) error {
+	var err error = NewMultiErr()
+	clusters := am.clusterList()
+	am.parallel(len(clusters), func(i int) {
+		if !clusters[i].Stopped() {
+			err.(*MultiErr).Add(clusters[i].Grow(n, cb))
+		}
+	})
+
+	if err.(*MultiErr).Size() > 0 {
+		return err
 	}
+	return nil
+}
 
-	return err
+// ShrinkAll will attempt to shrink every registered cluster by n instances, skipping any that have been stopped.
+// Clusters are shrunk in parallel, up to the manager's max concurrency.
+func (am *AgentMan) ShrinkAll(n uint8) error {
+	var err error = NewMultiErr()
+	clusters := am.clusterList()
+	am.parallel(len(clusters), func(i int) {
+		if !clusters[i].Stopped() {
+			if serr := clusters[i].Shrink(n); serr != nil {
+				err.(*MultiErr).Add(fmt.Errorf("unable to shrink \"%s\": %s", clusters[i].Name(), serr))
+			}
+		}
+	})
+
+	if err.(*MultiErr).Size() > 0 {
+		return err
+	}
+	return nil
 }
 
-// Stop will attempt to stop all currently running instances and clusters, removing all of them from the manager
-func (am *AgentMan) Stop() error {
+// AllInstances returns every live instance managed, whether single or a cluster member.  Single instances come first,
+// ordered by name, followed by the members of each cluster grouped by cluster name.
+func (am *AgentMan) AllInstances() []*TestInstance {
 	am.m.Lock()
 	defer am.m.Unlock()
 
-	var errs error = NewMultiErr()
+	names := make([]string, 0, len(am.instances))
+	for name := range am.instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-	wg := new(sync.WaitGroup)
+	all := make([]*TestInstance, 0, len(am.instances))
+	for _, name := range names {
+		if instance := am.instances[name]; !instance.Stopped() {
+			all = append(all, instance)
+		}
+	}
 
-	// TODO: maybe per single and per cluster?
-	wg.Add(2)
+	names = names[:0]
+	for name := range am.clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-	go func() {
-		for _, instance := range am.instances {
-			errs.(*MultiErr).Add(instance.Stop())
-		}
-		wg.Done()
-	}()
+	for _, name := range names {
+		cl := am.clusters[name]
+		cl.m.Lock()
+		all = append(all, cl.liveInstances()...)
+		cl.m.Unlock()
+	}
 
-	go func() {
-		for _, cluster := range am.clusters {
-			errs.(*MultiErr).Add(cluster.Stop())
-		}
-		wg.Done()
-	}()
+	return all
+}
 
-	wg.Wait()
+// instanceList returns the currently registered single instances
+func (am *AgentMan) instanceList() []*TestInstance {
+	am.m.Lock()
+	defer am.m.Unlock()
+	instances := make([]*TestInstance, 0, len(am.instances))
+	for _, instance := range am.instances {
+		instances = append(instances, instance)
+	}
+	return instances
+}
+
+// clusterList returns the currently registered clusters
+func (am *AgentMan) clusterList() []*TestCluster {
+	am.m.Lock()
+	defer am.m.Unlock()
+	clusters := make([]*TestCluster, 0, len(am.clusters))
+	for _, cluster := range am.clusters {
+		clusters = append(clusters, cluster)
+	}
+	return clusters
+}
 
+// Stop will attempt to stop all currently running instances and clusters, removing all of them from the manager.
+// Instances and clusters are stopped in parallel, up to the manager's max concurrency.
+func (am *AgentMan) Stop() error {
+	am.m.Lock()
+	instances := am.instances
+	clusters := am.clusters
+	for name := range am.supervisors {
+		am.unsupervise(name)
+	}
 	am.instances = make(Instances)
 	am.clusters = make(Clusters)
+	am.stopping += len(instances) + len(clusters)
+	am.m.Unlock()
+
+	defer am.doneStopping(len(instances) + len(clusters))
+
+	var errs error = NewMultiErr()
+
+	stops := make([]func(), 0, len(instances)+len(clusters))
+	for name, instance := range instances {
+		name, instance := name, instance
+		stops = append(stops, func() {
+			errs.(*MultiErr).Add(instance.Stop())
+			am.hooks.stopped(name)
+		})
+	}
+	for _, cluster := range clusters {
+		cluster := cluster
+		stops = append(stops, func() {
+			errs.(*MultiErr).Add(cluster.Stop())
+		})
+	}
+
+	am.parallel(len(stops), func(i int) {
+		stops[i]()
+	})
 
 	if errs.(*MultiErr).Size() > 0 {
 		return errs
@@ -486,7 +1831,7 @@ func (am *AgentMan) ClusterNames() []string {
 	am.m.Lock()
 	defer am.m.Unlock()
 	names := make([]string, 0)
-	for name := range am.instances {
+	for name := range am.clusters {
 		names = append(names, name)
 	}
 	return names
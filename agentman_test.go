@@ -1,10 +1,26 @@
 package agentman_test
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/dcarbone/agentman"
+	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/consul/testutil"
 	"github.com/steakknife/devnull"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 )
 
 const (
@@ -23,6 +39,12 @@ func shutupCluster(_ string, _ uint8, conf *testutil.TestServerConfig) {
 	conf.Stderr = devnull.Writer
 }
 
+// quietCluster is shutupCluster with only instance 0 bootstrapping, as DefaultClusterServerConfigCallback has it
+func quietCluster(name string, num uint8, conf *testutil.TestServerConfig) {
+	agentman.DefaultClusterServerConfigCallback(name, num, conf)
+	shutupCluster(name, num, conf)
+}
+
 func TestTestInstance(t *testing.T) {
 	var inst *agentman.TestInstance
 	var err error
@@ -35,6 +57,191 @@ func TestTestInstance(t *testing.T) {
 		}
 	})
 
+	t.Run("Ping", func(t *testing.T) {
+		if err := inst.Ping(); err != nil {
+			t.Logf("Unable to Ping(): %s", err)
+			t.FailNow()
+		}
+	})
+
+	t.Run("DumpKV", func(t *testing.T) {
+		for _, key := range []string{"agentman/dump/a", "agentman/dump/b"} {
+			if _, err := inst.APIClient().KV().Put(&api.KVPair{Key: key, Value: []byte(key)}, nil); err != nil {
+				t.Logf("Unable to put %s: %s", key, err)
+				t.FailNow()
+			}
+		}
+		kv, err := inst.DumpKV("agentman/dump/")
+		if err != nil {
+			t.Logf("Unable to DumpKV(): %s", err)
+			t.FailNow()
+		}
+		if len(kv) != 2 || string(kv["agentman/dump/a"]) != "agentman/dump/a" {
+			t.Logf("Expected 2 dumped keys, saw: %v", kv)
+			t.FailNow()
+		}
+	})
+
+	t.Run("WaitForServer", func(t *testing.T) {
+		if err := inst.WaitForServer(10 * time.Second); err != nil {
+			t.Logf("Unable to WaitForServer(): %s", err)
+			t.FailNow()
+		}
+	})
+
+	t.Run("CatalogDeregisterNode", func(t *testing.T) {
+		const node = "test-node-1"
+		_, err := inst.APIClient().Catalog().Register(&api.CatalogRegistration{Node: node, Address: "127.0.0.1"}, nil)
+		if err != nil {
+			t.Logf("Unable to register node: %s", err)
+			t.FailNow()
+		}
+		err = inst.CatalogDeregisterNode(node)
+		if err != nil {
+			t.Logf("Unable to CatalogDeregisterNode(): %s", err)
+			t.FailNow()
+		}
+		nodes, _, err := inst.APIClient().Catalog().Nodes(nil)
+		if err != nil {
+			t.Logf("Unable to list nodes: %s", err)
+			t.FailNow()
+		}
+		for _, n := range nodes {
+			if n.Node == node {
+				t.Logf("Expected node %s to have been deregistered", node)
+				t.FailNow()
+			}
+		}
+	})
+
+	t.Run("TxnKV", func(t *testing.T) {
+		const key = "agentman/txn"
+		_, err := inst.APIClient().KV().Put(&api.KVPair{Key: key, Value: []byte("first")}, nil)
+		if err != nil {
+			t.Logf("Unable to write key: %s", err)
+			t.FailNow()
+		}
+		kv, _, err := inst.APIClient().KV().Get(key, nil)
+		if err != nil || kv == nil {
+			t.Logf("Unable to read key: %v %v", kv, err)
+			t.FailNow()
+		}
+
+		ok, _, err := inst.TxnKV(api.KVTxnOps{
+			&api.KVTxnOp{Verb: api.KVCAS, Key: key, Value: []byte("stale"), Index: kv.ModifyIndex + 1},
+		})
+		if err != nil {
+			t.Logf("Unable to TxnKV(): %s", err)
+			t.FailNow()
+		}
+		if ok {
+			t.Log("Expected check-and-set with stale index to roll back")
+			t.FailNow()
+		}
+
+		ok, _, err = inst.TxnKV(api.KVTxnOps{
+			&api.KVTxnOp{Verb: api.KVCAS, Key: key, Value: []byte("second"), Index: kv.ModifyIndex},
+		})
+		if err != nil {
+			t.Logf("Unable to TxnKV(): %s", err)
+			t.FailNow()
+		}
+		if !ok {
+			t.Log("Expected check-and-set with current index to commit")
+			t.FailNow()
+		}
+	})
+
+	t.Run("Intentions", func(t *testing.T) {
+		err = inst.CreateIntention("web", "db", true)
+		if err != nil {
+			t.Logf("Unable to CreateIntention(): %s", err)
+			t.FailNow()
+		}
+		intentions, err := inst.ListIntentions()
+		if err != nil {
+			t.Logf("Unable to ListIntentions(): %s", err)
+			t.FailNow()
+		}
+		for _, ixn := range intentions {
+			if ixn.SourceName == "web" && ixn.DestinationName == "db" && ixn.Action == api.IntentionActionAllow {
+				return
+			}
+		}
+		t.Logf("Expected allow intention from web to db, saw: %v", intentions)
+		t.FailNow()
+	})
+
+	t.Run("Sessions", func(t *testing.T) {
+		id, err := inst.CreateSession(&api.SessionEntry{TTL: "30s"})
+		if err != nil {
+			t.Logf("Unable to CreateSession(): %s", err)
+			t.FailNow()
+		}
+		if err = inst.RenewSession(id); err != nil {
+			t.Logf("Unable to RenewSession(): %s", err)
+			t.FailNow()
+		}
+
+		kv := &api.KVPair{Key: "agentman/session-lock", Value: []byte("held"), Session: id}
+		acquired, _, err := inst.APIClient().KV().Acquire(kv, nil)
+		if err != nil || !acquired {
+			t.Logf("Expected to acquire lock with session, saw %t: %v", acquired, err)
+			t.FailNow()
+		}
+		released, _, err := inst.APIClient().KV().Release(kv, nil)
+		if err != nil || !released {
+			t.Logf("Expected to release lock held by session, saw %t: %v", released, err)
+			t.FailNow()
+		}
+
+		if err = inst.DestroySession(id); err != nil {
+			t.Logf("Unable to DestroySession(): %s", err)
+			t.FailNow()
+		}
+		if err = inst.RenewSession(id); err == nil {
+			t.Log("Expected RenewSession() of destroyed session to fail")
+			t.FailNow()
+		}
+	})
+
+	t.Run("AcquireLock", func(t *testing.T) {
+		const key = "agentman/lock"
+
+		lock, err := inst.AcquireLock(key)
+		if err != nil {
+			t.Logf("Unable to AcquireLock(): %s", err)
+			t.FailNow()
+		}
+		kv, _, err := inst.APIClient().KV().Get(key, nil)
+		if err != nil || kv == nil || kv.Session == "" {
+			t.Logf("Expected lock key to be held by a session, saw %v: %v", kv, err)
+			t.FailNow()
+		}
+
+		if err = lock.Unlock(); err != nil {
+			t.Logf("Unable to Unlock(): %s", err)
+			t.FailNow()
+		}
+		kv, _, err = inst.APIClient().KV().Get(key, nil)
+		if err != nil || kv == nil || kv.Session != "" {
+			t.Logf("Expected lock key to be released, saw %v: %v", kv, err)
+			t.FailNow()
+		}
+	})
+
+	t.Run("ConnectCA", func(t *testing.T) {
+		conf, err := inst.ConnectCAGetConfig()
+		if err != nil {
+			t.Logf("Unable to ConnectCAGetConfig(): %s", err)
+			t.FailNow()
+		}
+		if conf.Provider != "consul" {
+			t.Logf("Expected a fresh server to use the built-in CA provider, saw: %q", conf.Provider)
+			t.FailNow()
+		}
+	})
+
 	if inst != nil {
 		err = inst.Stop()
 		if err != nil {
@@ -43,47 +250,1744 @@ func TestTestInstance(t *testing.T) {
 	}
 }
 
+func TestChainCallbacks(t *testing.T) {
+	t.Run("Instance", func(t *testing.T) {
+		conf := new(testutil.TestServerConfig)
+		agentman.ChainCallbacks(
+			func(conf *testutil.TestServerConfig) { conf.LogLevel = "first" },
+			nil,
+			func(conf *testutil.TestServerConfig) { conf.LogLevel += ",second" },
+		)(conf)
+		if conf.LogLevel != "first,second" {
+			t.Logf("Expected callbacks to apply in order, saw: %q", conf.LogLevel)
+			t.FailNow()
+		}
+	})
+
+	t.Run("Cluster", func(t *testing.T) {
+		conf := new(testutil.TestServerConfig)
+		agentman.ChainClusterCallbacks(
+			func(name string, num uint8, conf *testutil.TestServerConfig) {
+				conf.NodeName = fmt.Sprintf("%s-%d", name, num)
+			},
+			func(name string, num uint8, conf *testutil.TestServerConfig) { conf.NodeName += "-second" },
+		)(ClusterName1, 2, conf)
+		if expected := ClusterName1 + "-2-second"; conf.NodeName != expected {
+			t.Logf("Expected node name %q, saw: %q", expected, conf.NodeName)
+			t.FailNow()
+		}
+	})
+}
+
+func TestTestInstanceExtraConfig(t *testing.T) {
+	inst, err := agentman.NewTestInstance(InstanceName1, shutup, agentman.WithExtraConfig(`{"rejoin_after_leave": true}`))
+	if err != nil {
+		t.Logf("Error during NewTestInstance(): %s", err)
+		t.FailNow()
+	}
+	defer inst.Stop()
+
+	self, err := inst.APIClient().Agent().Self()
+	if err != nil {
+		t.Logf("Unable to query agent self: %s", err)
+		t.FailNow()
+	}
+	if rejoin, _ := self["DebugConfig"]["RejoinAfterLeave"].(bool); !rejoin {
+		t.Logf("Expected RejoinAfterLeave to be true, saw: %v", self["DebugConfig"]["RejoinAfterLeave"])
+		t.FailNow()
+	}
+}
+
+type countingTransport struct {
+	count int32
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&c.count, 1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestTestInstanceAPIConfig(t *testing.T) {
+	transport := new(countingTransport)
+
+	inst, err := agentman.NewTestInstance(InstanceName1, shutup, agentman.WithAPIConfig(func(conf *api.Config) {
+		conf.Address = "127.0.0.1:1"
+		conf.HttpClient = &http.Client{Transport: transport, Timeout: 10 * time.Second}
+	}))
+	if err != nil {
+		t.Logf("Error during NewTestInstance(): %s", err)
+		t.FailNow()
+	}
+	defer inst.Stop()
+
+	_, err = inst.APIClient().KV().Put(&api.KVPair{Key: "key", Value: []byte("value")}, nil)
+	if err != nil {
+		t.Logf("Unable to put KV with custom api config: %s", err)
+		t.FailNow()
+	}
+	if atomic.LoadInt32(&transport.count) == 0 {
+		t.Log("Expected request to go through custom http client")
+		t.FailNow()
+	}
+}
+
+func TestTestInstanceArgs(t *testing.T) {
+	inst, err := agentman.NewTestInstance(InstanceName1, shutup, agentman.WithArgs("-node-meta", "origin:args"))
+	if err != nil {
+		t.Logf("Error during NewTestInstance(): %s", err)
+		t.FailNow()
+	}
+	defer inst.Stop()
+
+	node, err := inst.CatalogNode()
+	if err != nil {
+		t.Logf("Unable to query catalog node: %s", err)
+		t.FailNow()
+	}
+	if node == nil || node.Node.Meta["origin"] != "args" {
+		t.Log("Expected node meta set via args to be present")
+		t.FailNow()
+	}
+
+	_, err = agentman.NewTestInstance(InstanceName1, shutup, agentman.WithArgs("-data-dir=/tmp"))
+	if err == nil {
+		t.Log("Expected error from NewTestInstance() with conflicting -data-dir arg")
+		t.FailNow()
+	}
+}
+
+func TestTestInstancePorts(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Logf("Unable to find a free port: %s", err)
+		t.FailNow()
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	inst, err := agentman.NewTestInstance(InstanceName1, shutup, agentman.WithPorts(&testutil.TestPortConfig{HTTP: port}))
+	if err != nil {
+		t.Logf("Error during NewTestInstance(): %s", err)
+		t.FailNow()
+	}
+	defer inst.Stop()
+
+	if !strings.HasSuffix(inst.HTTPAddr(), ":"+strconv.Itoa(port)) {
+		t.Logf("Expected HTTP address to use port %d, saw: %s", port, inst.HTTPAddr())
+		t.FailNow()
+	}
+
+	_, err = agentman.NewTestInstance(InstanceName1, shutup, agentman.WithPorts(&testutil.TestPortConfig{HTTP: port, DNS: port}))
+	if err == nil {
+		t.Log("Expected error from NewTestInstance() with conflicting ports")
+		t.FailNow()
+	}
+}
+
+func TestTestClusterPorts(t *testing.T) {
+	cluster, err := agentman.NewTestCluster(ClusterName1, 3, quietCluster, agentman.WithPorts(&testutil.TestPortConfig{HTTP: 8500}))
+	if err == nil {
+		cluster.Stop()
+		t.Log("Expected error from NewTestCluster() with ports shared by every member")
+		t.FailNow()
+	}
+}
+
+func TestTestClusterNodeID(t *testing.T) {
+	cluster, err := agentman.NewTestCluster(ClusterName1, 3, quietCluster, agentman.WithNodeID("8e4b1dc0-2d1c-4cbb-9f4f-3c5d5e6f7a8b"))
+	if err == nil {
+		cluster.Stop()
+		t.Log("Expected error from NewTestCluster() with a node id shared by every member")
+		t.FailNow()
+	}
+}
+
+func TestTestInstanceTelemetry(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Logf("Unable to listen for statsd packets: %s", err)
+		t.FailNow()
+	}
+	defer conn.Close()
+
+	inst, err := agentman.NewTestInstance(InstanceName1, shutup, agentman.WithTelemetry(&agentman.TelemetryConfig{
+		StatsdAddr: conn.LocalAddr().String(),
+	}))
+	if err != nil {
+		t.Logf("Error during NewTestInstance(): %s", err)
+		t.FailNow()
+	}
+	defer inst.Stop()
+
+	conn.SetReadDeadline(time.Now().Add(15 * time.Second))
+	buf := make([]byte, 4096)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Logf("Expected statsd packet from instance, saw: %s", err)
+		t.FailNow()
+	}
+	if !strings.Contains(string(buf[:n]), "consul.") {
+		t.Logf("Expected consul metrics in statsd packet, saw: %q", string(buf[:n]))
+		t.FailNow()
+	}
+}
+
+func TestTestInstanceReloadConfig(t *testing.T) {
+	inst, err := agentman.NewTestInstance(InstanceName1, func(conf *testutil.TestServerConfig) {
+		shutup(conf)
+		conf.LogLevel = "ERR"
+	})
+	if err != nil {
+		t.Logf("Error during NewTestInstance(): %s", err)
+		t.FailNow()
+	}
+	defer inst.Stop()
+
+	err = inst.ReloadConfig(func(conf *testutil.TestServerConfig) {
+		conf.LogLevel = "DEBUG"
+	})
+	if err != nil {
+		t.Logf("Unable to ReloadConfig(): %s", err)
+		t.FailNow()
+	}
+	if level := inst.Config().LogLevel; level != "DEBUG" {
+		t.Logf("Expected retained config to have log level DEBUG, saw %s", level)
+		t.FailNow()
+	}
+
+	for attempt := 0; attempt < 20; attempt++ {
+		// requests are logged at debug
+		inst.Ping()
+		if strings.Contains(inst.Logs(), "[DEBUG]") {
+			return
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	t.Log("Expected debug output once log level was reloaded")
+	t.FailNow()
+}
+
+func TestTestInstanceNodeID(t *testing.T) {
+	const nodeID = "6f2b8a4e-1c3d-4e5f-8a9b-0c1d2e3f4a5b"
+
+	inst, err := agentman.NewTestInstance(InstanceName1, shutup, agentman.WithNodeID(nodeID))
+	if err != nil {
+		t.Logf("Error during NewTestInstance(): %s", err)
+		t.FailNow()
+	}
+	defer inst.Stop()
+
+	self, err := inst.APIClient().Agent().Self()
+	if err != nil {
+		t.Logf("Unable to query agent self: %s", err)
+		t.FailNow()
+	}
+	if id, _ := self["Config"]["NodeID"].(string); id != nodeID {
+		t.Logf("Expected node id %s, saw %q", nodeID, id)
+		t.FailNow()
+	}
+
+	_, err = agentman.NewTestInstance(InstanceName1, shutup, agentman.WithNodeID("not-a-uuid"))
+	if err == nil {
+		t.Log("Expected error from NewTestInstance() with invalid node id")
+		t.FailNow()
+	}
+}
+
+func TestTestInstanceConfigGetters(t *testing.T) {
+	inst, err := agentman.NewTestInstance(InstanceName1, func(conf *testutil.TestServerConfig) {
+		shutup(conf)
+		conf.LogLevel = "WARN"
+	})
+	if err != nil {
+		t.Logf("Error during NewTestInstance(): %s", err)
+		t.FailNow()
+	}
+
+	if bootstrap, err := inst.Bootstrap(); err != nil || !bootstrap {
+		t.Logf("Expected Bootstrap() to be true, saw %t: %v", bootstrap, err)
+		t.FailNow()
+	}
+	if server, err := inst.Server(); err != nil || !server {
+		t.Logf("Expected Server() to be true, saw %t: %v", server, err)
+		t.FailNow()
+	}
+	if level, err := inst.LogLevel(); err != nil || level != "WARN" {
+		t.Logf("Expected LogLevel() to be WARN, saw %q: %v", level, err)
+		t.FailNow()
+	}
+	if expect, err := inst.BootstrapExpect(); err != nil || expect != 0 {
+		t.Logf("Expected BootstrapExpect() to be 0, saw %d: %v", expect, err)
+		t.FailNow()
+	}
+
+	inst.Stop()
+
+	if _, err = inst.LogLevel(); !errors.Is(err, agentman.ErrInstanceDefunct) {
+		t.Logf("Expected LogLevel() of stopped instance to return ErrInstanceDefunct, saw: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestTestInstanceSetAgentToken(t *testing.T) {
+	inst, err := agentman.NewTestInstance(InstanceName1, func(conf *testutil.TestServerConfig) {
+		shutup(conf)
+		conf.ACLDatacenter = "dc1"
+		conf.ACLDefaultPolicy = "deny"
+		conf.ACLMasterToken = "root"
+	})
+	if err != nil {
+		t.Logf("Error during NewTestInstance(): %s", err)
+		t.FailNow()
+	}
+	defer inst.Stop()
+
+	kv := &api.KVPair{Key: "agentman/token", Value: []byte("value")}
+
+	if _, err = inst.APIClient().KV().Put(kv, nil); err == nil {
+		t.Log("Expected anonymous KV write to be denied")
+		t.FailNow()
+	}
+
+	err = inst.SetAgentToken(agentman.AgentTokenDefault, "root")
+	if err != nil {
+		t.Logf("Unable to SetAgentToken(): %s", err)
+		t.FailNow()
+	}
+
+	if _, err = inst.APIClient().KV().Put(kv, nil); err != nil {
+		t.Logf("Expected KV write to succeed with default token set, saw: %s", err)
+		t.FailNow()
+	}
+}
+
+func TestTestInstanceIPv6(t *testing.T) {
+	l, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable: %s", err)
+	}
+	l.Close()
+
+	inst, err := agentman.NewTestInstance(InstanceName1, func(conf *testutil.TestServerConfig) {
+		shutup(conf)
+		conf.Bind = "::1"
+	})
+	if err != nil {
+		t.Logf("Error during NewTestInstance(): %s", err)
+		t.FailNow()
+	}
+	defer inst.Stop()
+
+	if !strings.HasPrefix(inst.LANAddr(), "[::1]:") {
+		t.Logf("Expected LANAddr() to be bracketed IPv6, saw: %s", inst.LANAddr())
+		t.FailNow()
+	}
+	if !strings.HasPrefix(inst.HTTPAddr(), "[::1]:") {
+		t.Logf("Expected HTTPAddr() to be bracketed IPv6, saw: %s", inst.HTTPAddr())
+		t.FailNow()
+	}
+
+	client := inst.APIClient()
+	if _, err = client.KV().Put(&api.KVPair{Key: "agentman/ipv6", Value: []byte("value")}, nil); err != nil {
+		t.Logf("Unable to write KV over IPv6: %s", err)
+		t.FailNow()
+	}
+	kv, _, err := client.KV().Get("agentman/ipv6", nil)
+	if err != nil || kv == nil || string(kv.Value) != "value" {
+		t.Logf("Unable to read KV back over IPv6: %v %v", kv, err)
+		t.FailNow()
+	}
+}
+
+func TestInstanceStartError(t *testing.T) {
+	inst, err := agentman.NewTestInstance(InstanceName1, func(conf *testutil.TestServerConfig) {
+		shutup(conf)
+		conf.LogLevel = "not-a-level"
+	})
+	if err == nil {
+		inst.Stop()
+		t.Log("Expected error from NewTestInstance() with invalid log level")
+		t.FailNow()
+	}
+
+	var startErr *agentman.InstanceStartError
+	if !errors.As(err, &startErr) {
+		t.Logf("Expected error to be *InstanceStartError, saw: %T", err)
+		t.FailNow()
+	}
+	if startErr.Phase != agentman.PhaseServerStart {
+		t.Logf("Expected phase to be \"%s\", saw: \"%s\"", agentman.PhaseServerStart, startErr.Phase)
+		t.FailNow()
+	}
+}
+
+func TestInstancePostStart(t *testing.T) {
+	hookErr := errors.New("post start failed")
+
+	inst, err := agentman.NewTestInstance(InstanceName1, shutup, agentman.WithPostStart(func(inst *agentman.TestInstance) error {
+		if _, err := inst.APIClient().KV().Put(&api.KVPair{Key: "seed", Value: []byte("value")}, nil); err != nil {
+			return err
+		}
+		return hookErr
+	}))
+	if err == nil {
+		inst.Stop()
+		t.Log("Expected error from NewTestInstance() with failing post start hook")
+		t.FailNow()
+	}
+
+	var startErr *agentman.InstanceStartError
+	if !errors.As(err, &startErr) || startErr.Phase != agentman.PhasePostStart {
+		t.Logf("Expected *InstanceStartError in phase \"%s\", saw: %v", agentman.PhasePostStart, err)
+		t.FailNow()
+	}
+	if !errors.Is(err, hookErr) {
+		t.Logf("Expected error to wrap hook error, saw: %s", err)
+		t.FailNow()
+	}
+}
+
 func TestTestCluster(t *testing.T) {
 	var cluster *agentman.TestCluster
 	var err error
 
 	t.Run("New", func(t *testing.T) {
-		cluster, err = agentman.NewTestCluster(ClusterName1, 3, shutupCluster)
+		var done, total int
+		cluster, err = agentman.NewTestCluster(ClusterName1, 3, shutupCluster, agentman.WithProgress(func(d, n int) {
+			done, total = d, n
+		}))
 		if nil != err {
 			t.Logf("Error during NewTestCluster(): %s", err)
 			t.FailNow()
 		}
+		if done != 3 || total != 3 {
+			t.Logf("Expected final progress to be 3/3, saw: %d/%d", done, total)
+			t.FailNow()
+		}
 	})
 
-	t.Run("Grow", func(t *testing.T) {
-		err = cluster.Grow(2, shutupCluster)
+	t.Run("HasQuorum", func(t *testing.T) {
+		ok, err := cluster.HasQuorum()
 		if err != nil {
-			t.Logf("Unable to Grow(): %s", err)
+			t.Logf("Unable to HasQuorum(): %s", err)
 			t.FailNow()
 		}
-		if cluster.Size() != 5 {
-			t.Logf("Expected cluster size to be 5, saw: %d", cluster.Size())
+		if !ok {
+			t.Log("Expected freshly created cluster to have quorum")
 			t.FailNow()
 		}
 	})
 
-	t.Run("Shrink", func(t *testing.T) {
-		err = cluster.Shrink(3)
+	t.Run("Logs", func(t *testing.T) {
+		logs := cluster.Logs()
+		if len(logs) != cluster.Size() {
+			t.Logf("Expected logs for %d instances, saw: %d", cluster.Size(), len(logs))
+			t.FailNow()
+		}
+		buf := new(strings.Builder)
+		if err := cluster.DumpLogs(buf); err != nil {
+			t.Logf("Unable to DumpLogs(): %s", err)
+			t.FailNow()
+		}
+		if !strings.HasPrefix(buf.String(), "["+ClusterName1+"-") {
+			t.Logf("Expected dumped logs to be prefixed with instance name, saw: %q", buf.String())
+			t.FailNow()
+		}
+	})
+
+	t.Run("LeaderClient", func(t *testing.T) {
+		client, err := cluster.LeaderClient()
 		if err != nil {
-			t.Logf("Unable to Shrink(): %s", err)
-			// TODO: the errors returned by the testutil package seem to be...mostly meaningless.
-			// t.FailNow()
+			t.Logf("Unable to LeaderClient(): %s", err)
+			t.FailNow()
 		}
-		if cluster.Size() != 2 {
-			t.Logf("Expected cluster size to be 2, saw: %d", cluster.Size())
+		if _, err = client.Status().Leader(); err != nil {
+			t.Logf("Unable to query leader client: %s", err)
 			t.FailNow()
 		}
 	})
 
-	if cluster != nil {
-		err = cluster.Stop()
+	t.Run("RaftStats", func(t *testing.T) {
+		stats, err := cluster.RaftStats()
 		if err != nil {
-			t.Logf("Error seen while stopping cluster: %s", err)
+			t.Logf("Unable to RaftStats(): %s", err)
+			t.FailNow()
+		}
+		if len(stats) != cluster.Size() {
+			t.Logf("Expected raft stats for %d instances, saw %d", cluster.Size(), len(stats))
+			t.FailNow()
+		}
+		for name, raft := range stats {
+			if _, ok := raft["applied_index"]; !ok {
+				t.Logf("Expected applied_index in raft stats of %s, saw: %v", name, raft)
+				t.FailNow()
+			}
 		}
+	})
+
+	t.Run("IsLeader", func(t *testing.T) {
+		leaders := 0
+		for i := 0; i < cluster.Size(); i++ {
+			ok, err := cluster.Instance(uint8(i)).IsLeader()
+			if err != nil {
+				t.Logf("Unable to IsLeader() on instance %d: %s", i, err)
+				t.FailNow()
+			}
+			if ok {
+				leaders++
+			}
+		}
+		if leaders != 1 {
+			t.Logf("Expected exactly 1 leader, saw: %d", leaders)
+			t.FailNow()
+		}
+	})
+
+	t.Run("AutopilotHealth", func(t *testing.T) {
+		health, err := cluster.AutopilotHealth()
+		if err != nil {
+			t.Logf("Unable to AutopilotHealth(): %s", err)
+			t.FailNow()
+		}
+		if len(health.Servers) != 3 {
+			t.Logf("Expected autopilot to report on 3 servers, saw: %d", len(health.Servers))
+			t.FailNow()
+		}
+	})
+
+	t.Run("Events", func(t *testing.T) {
+		id, err := cluster.Instance(0).FireEvent("deploy", []byte("v2"))
+		if err != nil {
+			t.Logf("Unable to FireEvent(): %s", err)
+			t.FailNow()
+		}
+		for attempt := 0; attempt < 20; attempt++ {
+			events, err := cluster.Instance(1).ListEvents("deploy")
+			if err != nil {
+				t.Logf("Unable to ListEvents(): %s", err)
+				t.FailNow()
+			}
+			for _, event := range events {
+				if event.ID == id && string(event.Payload) == "v2" {
+					return
+				}
+			}
+			time.Sleep(250 * time.Millisecond)
+		}
+		t.Logf("Expected event \"%s\" to be observed by another instance", id)
+		t.FailNow()
+	})
+
+	t.Run("Grow", func(t *testing.T) {
+		err = cluster.Grow(2, shutupCluster)
+		if err != nil {
+			t.Logf("Unable to Grow(): %s", err)
+			t.FailNow()
+		}
+		if cluster.Size() != 5 {
+			t.Logf("Expected cluster size to be 5, saw: %d", cluster.Size())
+			t.FailNow()
+		}
+		if err = cluster.WaitForSize(5, 10*time.Second); err != nil {
+			t.Logf("Unable to WaitForSize(): %s", err)
+			t.FailNow()
+		}
+	})
+
+	t.Run("Shrink", func(t *testing.T) {
+		err = cluster.Shrink(3)
+		if err != nil {
+			t.Logf("Unable to Shrink(): %s", err)
+			// TODO: the errors returned by the testutil package seem to be...mostly meaningless.
+			// t.FailNow()
+		}
+		if cluster.Size() != 2 {
+			t.Logf("Expected cluster size to be 2, saw: %d", cluster.Size())
+			t.FailNow()
+		}
+	})
+
+	t.Run("RestartAll", func(t *testing.T) {
+		err = cluster.RestartAll()
+		if err != nil {
+			t.Logf("Unable to RestartAll(): %s", err)
+			t.FailNow()
+		}
+		if cluster.Size() != 2 {
+			t.Logf("Expected cluster size to be 2 after restart, saw: %d", cluster.Size())
+			t.FailNow()
+		}
+	})
+
+	if cluster != nil {
+		err = cluster.Stop()
+		if err != nil {
+			t.Logf("Error seen while stopping cluster: %s", err)
+		}
+	}
+}
+
+func TestTestClusterSizes(t *testing.T) {
+	for _, size := range []uint8{1, 2} {
+		t.Run(fmt.Sprintf("Size%d", size), func(t *testing.T) {
+			cluster, err := agentman.NewTestCluster(ClusterName1, size, quietCluster)
+			if err != nil {
+				t.Logf("Error during NewTestCluster(): %s", err)
+				t.FailNow()
+			}
+			defer cluster.Stop()
+
+			if cluster.Size() != int(size) {
+				t.Logf("Expected cluster size to be %d, saw: %d", size, cluster.Size())
+				t.FailNow()
+			}
+			for i := uint8(0); i < size; i++ {
+				if cluster.Instance(i).Stopped() {
+					t.Logf("Expected instance %d to be live", i)
+					t.FailNow()
+				}
+			}
+		})
+	}
+}
+
+func TestTestClusterRaftRemovePeer(t *testing.T) {
+	cluster, err := agentman.NewTestCluster(ClusterName1, 3, quietCluster)
+	if err != nil {
+		t.Logf("Error during NewTestCluster(): %s", err)
+		t.FailNow()
+	}
+	defer cluster.Stop()
+
+	conf := cluster.Instance(2).Config()
+	addr := net.JoinHostPort(conf.Bind, strconv.Itoa(conf.Ports.Server))
+
+	err = cluster.Instance(2).Stop()
+	if err != nil {
+		t.Logf("Error seen while stopping instance: %s", err)
+	}
+
+	err = cluster.RaftRemovePeer(2)
+	if err != nil {
+		t.Logf("Unable to RaftRemovePeer(): %s", err)
+		t.FailNow()
+	}
+
+	raft, err := cluster.RaftConfiguration()
+	if err != nil {
+		t.Logf("Unable to RaftConfiguration(): %s", err)
+		t.FailNow()
+	}
+	for _, server := range raft.Servers {
+		if server.Address == addr {
+			t.Logf("Expected server %s to have been removed from raft configuration", addr)
+			t.FailNow()
+		}
+	}
+}
+
+func TestTestClusterNoBootstrap(t *testing.T) {
+	cluster, err := agentman.NewTestCluster(ClusterName1, 3, quietCluster, agentman.WithNoBootstrap())
+	if err != nil {
+		t.Logf("Error during NewTestCluster(): %s", err)
+		t.FailNow()
+	}
+	defer cluster.Stop()
+
+	for i := 0; i < cluster.Size(); i++ {
+		if bootstrap, err := cluster.Instance(uint8(i)).Bootstrap(); err != nil || bootstrap {
+			t.Logf("Expected instance %d not to bootstrap, saw %t: %v", i, bootstrap, err)
+			t.FailNow()
+		}
+	}
+
+	if err = cluster.WaitForLeader(2 * time.Second); err == nil {
+		t.Log("Expected WaitForLeader() to time out on an un-bootstrapped cluster")
+		t.FailNow()
+	}
+}
+
+func TestTestClusterGrowResult(t *testing.T) {
+	cluster, err := agentman.NewTestCluster(ClusterName1, 1, quietCluster)
+	if err != nil {
+		t.Logf("Error during NewTestCluster(): %s", err)
+		t.FailNow()
+	}
+	defer cluster.Stop()
+
+	added, err := cluster.GrowResult(2, quietCluster)
+	if err != nil {
+		t.Logf("Unable to GrowResult(): %s", err)
+		t.FailNow()
+	}
+	if len(added) != 2 {
+		t.Logf("Expected 2 added instances, saw %d", len(added))
+		t.FailNow()
+	}
+	for i, instance := range added {
+		if !instance.Same(cluster.Instance(uint8(i + 1))) {
+			t.Logf("Expected added instance %d to be cluster instance %d, saw %s", i, i+1, instance.Name())
+			t.FailNow()
+		}
+	}
+}
+
+func TestTestClusterGrowWithoutBootstrapInstance(t *testing.T) {
+	cluster, err := agentman.NewTestCluster(ClusterName1, 3, quietCluster)
+	if err != nil {
+		t.Logf("Error during NewTestCluster(): %s", err)
+		t.FailNow()
+	}
+	defer cluster.Stop()
+
+	err = cluster.Instance(0).Stop()
+	if err != nil {
+		t.Logf("Error seen while stopping instance: %s", err)
+	}
+
+	err = cluster.WaitForLeader(agentman.DefaultLeaderTimeout)
+	if err != nil {
+		t.Logf("Cluster did not recover a leader: %s", err)
+		t.FailNow()
+	}
+
+	err = cluster.Grow(1, quietCluster)
+	if err != nil {
+		t.Logf("Unable to Grow() without instance 0: %s", err)
+		t.FailNow()
+	}
+
+	members, err := cluster.Instance(3).APIClient().Agent().Members(false)
+	if err != nil {
+		t.Logf("Unable to list members: %s", err)
+		t.FailNow()
+	}
+	alive := 0
+	for _, member := range members {
+		if member.Status == 1 {
+			alive++
+		}
+	}
+	if alive != 3 {
+		t.Logf("Expected new instance to see 3 alive members, saw: %d", alive)
+		t.FailNow()
+	}
+}
+
+func TestTestClusterGrowAndWait(t *testing.T) {
+	cluster, err := agentman.NewTestCluster(ClusterName1, 3, quietCluster)
+	if err != nil {
+		t.Logf("Error during NewTestCluster(): %s", err)
+		t.FailNow()
+	}
+	defer cluster.Stop()
+
+	err = cluster.GrowAndWait(1, quietCluster, agentman.DefaultLeaderTimeout)
+	if err != nil {
+		t.Logf("Unable to GrowAndWait(): %s", err)
+		t.FailNow()
+	}
+	if cluster.Size() != 4 {
+		t.Logf("Expected cluster size to be 4, saw: %d", cluster.Size())
+		t.FailNow()
+	}
+}
+
+func TestTestClusterNodeMeta(t *testing.T) {
+	zone := func(num uint8) map[string]string {
+		return map[string]string{"zone": fmt.Sprintf("zone-%d", num)}
+	}
+
+	cluster, err := agentman.NewTestCluster(ClusterName1, 2, quietCluster, agentman.WithNodeMeta(zone))
+	if err != nil {
+		t.Logf("Error during NewTestCluster(): %s", err)
+		t.FailNow()
+	}
+	defer cluster.Stop()
+
+	for i := uint8(0); i < 2; i++ {
+		node, err := cluster.Instance(i).CatalogNode()
+		if err != nil {
+			t.Logf("Unable to query catalog node: %s", err)
+			t.FailNow()
+		}
+		if node == nil || node.Node.Meta["zone"] != zone(i)["zone"] {
+			t.Logf("Expected instance %d to have zone %s", i, zone(i)["zone"])
+			t.FailNow()
+		}
+	}
+}
+
+func TestNewTestInstanceT(t *testing.T) {
+	var inst *agentman.TestInstance
+
+	t.Run("Create", func(t *testing.T) {
+		inst = agentman.NewTestInstanceT(t, InstanceName1, nil)
+		if inst.Stopped() {
+			t.Log("Expected instance to be running within the test")
+			t.FailNow()
+		}
+	})
+
+	if !inst.Stopped() {
+		t.Log("Expected instance to be stopped once its test completed")
+		t.FailNow()
+	}
+}
+
+func TestNewTestClusterT(t *testing.T) {
+	var cluster *agentman.TestCluster
+
+	t.Run("Create", func(t *testing.T) {
+		cluster = agentman.NewTestClusterT(t, ClusterName1, 2, nil)
+		if live := cluster.LiveSize(); live != 2 {
+			t.Logf("Expected 2 live members within the test, saw %d", live)
+			t.FailNow()
+		}
+	})
+
+	if !cluster.Stopped() {
+		t.Log("Expected cluster to be stopped once its test completed")
+		t.FailNow()
+	}
+}
+
+func TestTestClusterGrowWAN(t *testing.T) {
+	cluster, err := agentman.NewTestCluster(ClusterName1, 1, quietCluster)
+	if err != nil {
+		t.Logf("Error during NewTestCluster(): %s", err)
+		t.FailNow()
+	}
+	defer cluster.Stop()
+
+	err = cluster.Grow(1, func(name string, num uint8, conf *testutil.TestServerConfig) {
+		quietCluster(name, num, conf)
+		conf.Datacenter = "dc2"
+		conf.Bootstrap = true
+	}, agentman.WithWANJoin())
+	if err != nil {
+		t.Logf("Unable to Grow() over WAN: %s", err)
+		t.FailNow()
+	}
+
+	members, err := cluster.Instance(0).APIClient().Agent().Members(true)
+	if err != nil {
+		t.Logf("Unable to list WAN members: %s", err)
+		t.FailNow()
+	}
+	if len(members) != 2 {
+		t.Logf("Expected 2 WAN members, saw: %d", len(members))
+		t.FailNow()
+	}
+
+	var entries map[string][]*api.ServiceEntry
+	for attempt := 0; attempt < 40; attempt++ {
+		// dc2 may not have elected its leader yet
+		if entries, err = cluster.Instance(0).ServiceAllDCs("consul"); err == nil {
+			break
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	if err != nil {
+		t.Logf("Unable to ServiceAllDCs(): %s", err)
+		t.FailNow()
+	}
+	for _, dc := range []string{"dc1", "dc2"} {
+		if len(entries[dc]) != 1 {
+			t.Logf("Expected 1 consul service entry in %s, saw: %d", dc, len(entries[dc]))
+			t.FailNow()
+		}
+	}
+}
+
+func TestTestClusterSnapshot(t *testing.T) {
+	source, err := agentman.NewTestCluster(ClusterName1, 3, quietCluster)
+	if err != nil {
+		t.Logf("Error during NewTestCluster(): %s", err)
+		t.FailNow()
+	}
+	defer source.Stop()
+
+	_, err = source.Instance(0).APIClient().KV().Put(&api.KVPair{Key: "snapshot", Value: []byte("value")}, nil)
+	if err != nil {
+		t.Logf("Unable to put KV: %s", err)
+		t.FailNow()
+	}
+
+	data, err := source.Snapshot()
+	if err != nil {
+		t.Logf("Unable to Snapshot(): %s", err)
+		t.FailNow()
+	}
+
+	target, err := agentman.NewTestCluster(ClusterName1+"-restored", 3, quietCluster)
+	if err != nil {
+		t.Logf("Error during NewTestCluster(): %s", err)
+		t.FailNow()
+	}
+	defer target.Stop()
+
+	err = target.RestoreSnapshot(data)
+	if err != nil {
+		t.Logf("Unable to RestoreSnapshot(): %s", err)
+		t.FailNow()
+	}
+
+	kv, _, err := target.Instance(0).APIClient().KV().Get("snapshot", nil)
+	if err != nil || kv == nil || string(kv.Value) != "value" {
+		t.Logf("Expected restored cluster to hold KV, saw: %v %v", kv, err)
+		t.FailNow()
+	}
+}
+
+func TestTestClusterRestartAllSkipsStopped(t *testing.T) {
+	am := agentman.NewAgentMan()
+	defer am.Stop()
+
+	cluster, err := am.NewCluster(ClusterName1, 5, quietCluster)
+	if err != nil {
+		t.Logf("Error during NewCluster(): %s", err)
+		t.FailNow()
+	}
+
+	// three of five members remain live, enough for quorum
+	if err = cluster.StopInstance(3); err != nil {
+		t.Logf("Unable to StopInstance(3): %s", err)
+		t.FailNow()
+	}
+	if err = cluster.Instance(4).Kill(); err != nil {
+		t.Logf("Unable to Kill() instance 4: %s", err)
+		t.FailNow()
+	}
+
+	stopped := make([]string, 0)
+	am.OnStop(func(name string) {
+		stopped = append(stopped, name)
+	})
+
+	if err = cluster.RestartAll(); err != nil {
+		t.Logf("Unable to RestartAll() with stopped and killed members: %s", err)
+		t.FailNow()
+	}
+	if live := cluster.LiveSize(); live != 3 {
+		t.Logf("Expected stopped and killed members to stay down, saw live size %d", live)
+		t.FailNow()
+	}
+	if len(stopped) != 3 {
+		t.Logf("Expected stop hooks for the 3 live members only, saw: %v", stopped)
+		t.FailNow()
+	}
+}
+
+func TestTestClusterRoundRobinClient(t *testing.T) {
+	cluster, err := agentman.NewTestCluster(ClusterName1, 3, quietCluster)
+	if err != nil {
+		t.Logf("Error during NewTestCluster(): %s", err)
+		t.FailNow()
+	}
+	defer cluster.Stop()
+
+	client, err := cluster.RoundRobinClient()
+	if err != nil {
+		t.Logf("Unable to create RoundRobinClient(): %s", err)
+		t.FailNow()
+	}
+
+	seen := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		self, err := client.Agent().Self()
+		if err != nil {
+			t.Logf("Unable to query agent through round robin client: %s", err)
+			t.FailNow()
+		}
+		seen[self["Config"]["NodeName"].(string)]++
+	}
+
+	if len(seen) != 3 {
+		t.Logf("Expected requests to rotate across all 3 members, saw: %v", seen)
+		t.FailNow()
+	}
+	for name, n := range seen {
+		if n != 2 {
+			t.Logf("Expected each member to receive 2 requests, %s received %d", name, n)
+			t.FailNow()
+		}
+	}
+}
+
+func TestTestClusterExpandToSize(t *testing.T) {
+	cluster, err := agentman.NewTestCluster(ClusterName1, 3, quietCluster)
+	if err != nil {
+		t.Logf("Error during NewTestCluster(): %s", err)
+		t.FailNow()
+	}
+	defer cluster.Stop()
+
+	t.Run("Grow", func(t *testing.T) {
+		if err := cluster.ExpandToSize(5, quietCluster); err != nil {
+			t.Logf("Unable to ExpandToSize(5): %s", err)
+			t.FailNow()
+		}
+		if live := cluster.LiveSize(); live != 5 {
+			t.Logf("Expected live size 5, saw %d", live)
+			t.FailNow()
+		}
+	})
+
+	t.Run("NoOp", func(t *testing.T) {
+		if err := cluster.ExpandToSize(5, nil); err != nil {
+			t.Logf("Unable to ExpandToSize(5) at size 5: %s", err)
+			t.FailNow()
+		}
+		if size := cluster.Size(); size != 5 {
+			t.Logf("Expected size to remain 5, saw %d", size)
+			t.FailNow()
+		}
+	})
+
+	t.Run("Shrink", func(t *testing.T) {
+		// the stopped member is not counted, so reaching 3 live removes it along with one live member
+		if err := cluster.StopInstance(4); err != nil {
+			t.Logf("Unable to StopInstance(4): %s", err)
+			t.FailNow()
+		}
+		if err := cluster.ExpandToSize(3, quietCluster); err != nil {
+			t.Logf("Unable to ExpandToSize(3): %s", err)
+			t.FailNow()
+		}
+		if live, size := cluster.LiveSize(), cluster.Size(); live != 3 || size != 3 {
+			t.Logf("Expected live size and size 3, saw %d and %d", live, size)
+			t.FailNow()
+		}
+	})
+}
+
+func TestTestClusterStopInstance(t *testing.T) {
+	cluster, err := agentman.NewTestCluster(ClusterName1, 3, quietCluster)
+	if err != nil {
+		t.Logf("Error during NewTestCluster(): %s", err)
+		t.FailNow()
+	}
+	defer cluster.Stop()
+
+	if err = cluster.StopInstance(1); err != nil {
+		t.Logf("Unable to StopInstance(1): %s", err)
+		t.FailNow()
+	}
+	if !cluster.Instance(1).Stopped() {
+		t.Log("Expected instance 1 to be stopped")
+		t.FailNow()
+	}
+	if size, live := cluster.Size(), cluster.LiveSize(); size != 3 || live != 2 {
+		t.Logf("Expected size 3 and live size 2, saw %d and %d", size, live)
+		t.FailNow()
+	}
+
+	if err = cluster.RestartInstance(1); err != nil {
+		t.Logf("Unable to RestartInstance(1): %s", err)
+		t.FailNow()
+	}
+	if live := cluster.LiveSize(); live != 3 {
+		t.Logf("Expected live size 3 after restart, saw %d", live)
+		t.FailNow()
+	}
+	if err = cluster.WaitForSize(3, agentman.DefaultLeaderTimeout); err != nil {
+		t.Logf("Restarted instance did not rejoin: %s", err)
+		t.FailNow()
+	}
+}
+
+func BenchmarkTestClusterShrink(b *testing.B) {
+	for _, bench := range []struct {
+		name  string
+		limit int
+	}{
+		{"Sequential", 1},
+		{"Parallel", 0},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				cluster, err := agentman.NewTestCluster(ClusterName1, 7, quietCluster, agentman.WithMaxConcurrency(bench.limit))
+				if err != nil {
+					b.Logf("Error during NewTestCluster(): %s", err)
+					b.FailNow()
+				}
+				b.StartTimer()
+
+				err = cluster.Shrink(6)
+
+				b.StopTimer()
+				cluster.Stop()
+				if err != nil {
+					b.Logf("Unable to Shrink(): %s", err)
+					b.FailNow()
+				}
+			}
+		})
+	}
+}
+
+func TestTestClusterKill(t *testing.T) {
+	cluster, err := agentman.NewTestCluster(ClusterName1, 3, quietCluster)
+	if err != nil {
+		t.Logf("Error during NewTestCluster(): %s", err)
+		t.FailNow()
+	}
+	defer cluster.Stop()
+
+	killed := cluster.Instance(2)
+	nodeName := killed.Config().NodeName
+	if err = killed.Kill(); err != nil {
+		t.Logf("Unable to Kill(): %s", err)
+		t.FailNow()
+	}
+	if !killed.Stopped() {
+		t.Log("Expected killed instance to be defunct")
+		t.FailNow()
+	}
+
+	if _, err = waitForFailed(cluster, nodeName, 30*time.Second); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	alive, failed, left, err := cluster.MemberCounts()
+	if err != nil {
+		t.Logf("Unable to MemberCounts(): %s", err)
+		t.FailNow()
+	}
+	if alive != 2 || failed != 1 || left != 0 {
+		t.Logf("Expected 2 alive, 1 failed, and 0 left, saw %d, %d, and %d", alive, failed, left)
+		t.FailNow()
+	}
+}
+
+func TestTestClusterGossipTiming(t *testing.T) {
+	cluster, err := agentman.NewTestCluster(ClusterName1, 3, quietCluster, agentman.WithGossipTiming(&agentman.GossipTiming{
+		ProbeInterval: 100 * time.Millisecond,
+		ProbeTimeout:  50 * time.Millisecond,
+		SuspicionMult: 1,
+	}))
+	if err != nil {
+		t.Logf("Error during NewTestCluster(): %s", err)
+		t.FailNow()
+	}
+	defer cluster.Stop()
+
+	killed := cluster.Instance(2)
+	nodeName := killed.Config().NodeName
+	if err = killed.Kill(); err != nil {
+		t.Logf("Unable to Kill(): %s", err)
+		t.FailNow()
+	}
+
+	took, err := waitForFailed(cluster, nodeName, 30*time.Second)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	// with consul's defaults a suspect node has at least 4 probe intervals of 1s to refute before it is failed
+	if defaultFailureTime := 4 * time.Second; took >= defaultFailureTime {
+		t.Logf("Expected killed instance to be seen as failed faster than consul's default of %s, took %s", defaultFailureTime, took)
+		t.FailNow()
+	}
+	t.Logf("Killed instance seen as failed after %s", took)
+
+	_, err = agentman.NewTestInstance(InstanceName1, shutup, agentman.WithGossipTiming(&agentman.GossipTiming{
+		ProbeInterval: 100 * time.Millisecond,
+		ProbeTimeout:  200 * time.Millisecond,
+	}))
+	if err == nil {
+		t.Log("Expected error from NewTestInstance() with probe timeout longer than probe interval")
+		t.FailNow()
+	}
+}
+
+// waitForFailed polls the first member of cluster until it sees nodeName as failed, returning how long that took
+func waitForFailed(cluster *agentman.TestCluster, nodeName string, timeout time.Duration) (time.Duration, error) {
+	start := time.Now()
+	for time.Since(start) < timeout {
+		members, err := cluster.Instance(0).APIClient().Agent().Members(false)
+		if err != nil {
+			return 0, fmt.Errorf("unable to list members: %s", err)
+		}
+		for _, member := range members {
+			// 4 is serf's failed status, a graceful leave would show 3
+			if member.Name == nodeName && member.Status == 4 {
+				return time.Since(start), nil
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return 0, fmt.Errorf("expected %s to be seen as failed within %s", nodeName, timeout)
+}
+
+func TestTestClusterBootstrapTimeout(t *testing.T) {
+	cluster, err := agentman.NewTestCluster(ClusterName1, 1, func(name string, num uint8, conf *testutil.TestServerConfig) {
+		quietCluster(name, num, conf)
+		conf.Bootstrap = false
+	}, agentman.WithBootstrapTimeout(2*time.Second))
+	if err == nil {
+		cluster.Stop()
+		t.Log("Expected error from NewTestCluster() with no bootstrapping instance")
+		t.FailNow()
+	}
+	if !errors.Is(err, agentman.ErrBootstrapTimeout) {
+		t.Logf("Expected error to wrap ErrBootstrapTimeout, saw: %s", err)
+		t.FailNow()
+	}
+}
+
+func TestTestClusterConcurrentScaling(t *testing.T) {
+	cluster, err := agentman.NewTestCluster(ClusterName1, 3, quietCluster)
+	if err != nil {
+		t.Logf("Error during NewTestCluster(): %s", err)
+		t.FailNow()
+	}
+	defer cluster.Stop()
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 2; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			cluster.Grow(1, quietCluster)
+		}()
+		go func() {
+			defer wg.Done()
+			cluster.Shrink(1)
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		cluster.Stop()
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Minute):
+		t.Log("Concurrent Grow, Shrink, and Stop did not complete, possible deadlock")
+		t.FailNow()
+	}
+
+	if !cluster.Stopped() {
+		t.Log("Expected cluster to be stopped")
+		t.FailNow()
+	}
+	if err = cluster.Grow(1, quietCluster); err == nil {
+		t.Log("Expected Grow() on a stopped cluster to return an error")
+		t.FailNow()
+	}
+}
+
+func TestTestClusterStartError(t *testing.T) {
+	httpAddrs := make([]string, 0)
+
+	cluster, err := agentman.NewTestCluster(ClusterName1, 5, func(name string, num uint8, conf *testutil.TestServerConfig) {
+		quietCluster(name, num, conf)
+		if num == 2 {
+			conf.LogLevel = "not-a-level"
+		} else {
+			httpAddrs = append(httpAddrs, net.JoinHostPort("127.0.0.1", strconv.Itoa(conf.Ports.HTTP)))
+		}
+	})
+	if err == nil {
+		cluster.Stop()
+		t.Log("Expected error from NewTestCluster() with failing instance")
+		t.FailNow()
+	}
+
+	var startErr *agentman.ClusterStartError
+	if !errors.As(err, &startErr) {
+		t.Logf("Expected error to be *ClusterStartError, saw: %T", err)
+		t.FailNow()
+	}
+	if startErr.Started != 2 || startErr.Failed != 2 {
+		t.Logf("Expected 2 started and instance 2 to fail, saw %d started and instance %d fail", startErr.Started, startErr.Failed)
+		t.FailNow()
+	}
+
+	for _, addr := range httpAddrs {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			t.Logf("Expected instance at %s to have been stopped", addr)
+			t.FailNow()
+		}
+	}
+}
+
+func TestTestClusterStartErrorRetainData(t *testing.T) {
+	dataDirs := make([]string, 0)
+
+	cluster, err := agentman.NewTestCluster(ClusterName1, 3, func(name string, num uint8, conf *testutil.TestServerConfig) {
+		quietCluster(name, num, conf)
+		dataDirs = append(dataDirs, conf.DataDir)
+		if num == 2 {
+			conf.LogLevel = "not-a-level"
+		}
+	}, agentman.WithRetainDataOnFailure())
+	defer func() {
+		for _, dataDir := range dataDirs {
+			os.RemoveAll(filepath.Dir(dataDir))
+		}
+	}()
+	if err == nil {
+		cluster.Stop()
+		t.Log("Expected error from NewTestCluster() with failing instance")
+		t.FailNow()
+	}
+
+	if len(dataDirs) != 3 {
+		t.Logf("Expected 3 instances to have been configured, saw %d", len(dataDirs))
+		t.FailNow()
+	}
+	for _, dataDir := range dataDirs {
+		if _, err := os.Stat(filepath.Dir(dataDir)); err != nil {
+			t.Logf("Expected files of failed cluster to be retained at %s: %s", filepath.Dir(dataDir), err)
+			t.FailNow()
+		}
+	}
+}
+
+func TestTestClusterBestEffort(t *testing.T) {
+	attempts, done := 0, 0
+
+	cluster, err := agentman.NewTestClusterBestEffort(ClusterName1, 3, func(name string, num uint8, conf *testutil.TestServerConfig) {
+		quietCluster(name, num, conf)
+		attempts++
+		if attempts == 2 {
+			conf.LogLevel = "not-a-level"
+		}
+	}, agentman.WithProgress(func(d, _ int) {
+		done = d
+	}))
+	if cluster == nil {
+		t.Logf("Expected a cluster from NewTestClusterBestEffort(), saw error: %s", err)
+		t.FailNow()
+	}
+	defer cluster.Stop()
+
+	var me *agentman.MultiErr
+	if !errors.As(err, &me) || me.Size() != 1 {
+		t.Logf("Expected *MultiErr describing 1 failure, saw: %v", err)
+		t.FailNow()
+	}
+	if size := cluster.Size(); size != 2 {
+		t.Logf("Expected cluster to hold 2 live instances, saw %d", size)
+		t.FailNow()
+	}
+	if done != 2 {
+		t.Logf("Expected progress to count only the 2 started instances, saw %d", done)
+		t.FailNow()
+	}
+	if err = cluster.WaitForSize(2, agentman.DefaultLeaderTimeout); err != nil {
+		t.Logf("Surviving instances did not form a cluster: %s", err)
+		t.FailNow()
+	}
+}
+
+func TestTestClusterNonVoting(t *testing.T) {
+	if out, err := exec.Command("consul", "version").Output(); err != nil || !strings.Contains(string(out), "+ent") {
+		t.Skip("Non-voting servers require Consul Enterprise")
+	}
+
+	cluster, err := agentman.NewTestCluster(ClusterName1, 3, quietCluster)
+	if err != nil {
+		t.Logf("Error during NewTestCluster(): %s", err)
+		t.FailNow()
+	}
+	defer cluster.Stop()
+
+	err = cluster.GrowNonVoting(1, quietCluster)
+	if err != nil {
+		t.Logf("Unable to GrowNonVoting(): %s", err)
+		t.FailNow()
+	}
+
+	conf := cluster.Instance(3).Config()
+	addr := net.JoinHostPort(conf.Bind, strconv.Itoa(conf.Ports.Server))
+
+	raft, err := cluster.Instance(0).APIClient().Operator().RaftGetConfiguration(nil)
+	if err != nil {
+		t.Logf("Unable to RaftGetConfiguration(): %s", err)
+		t.FailNow()
+	}
+	for _, server := range raft.Servers {
+		if server.Address == addr {
+			if server.Voter {
+				t.Logf("Expected server %s to be non-voting", addr)
+				t.FailNow()
+			}
+			return
+		}
+	}
+	t.Logf("Server %s not found in raft configuration", addr)
+	t.FailNow()
+}
+
+func TestKillOrphans(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agentman-orphans")
+	if err != nil {
+		t.Logf("Unable to create temp dir: %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(stateFile string) {
+		agentman.StateFile = stateFile
+	}(agentman.StateFile)
+	agentman.StateFile = filepath.Join(dir, "agentman.state")
+
+	// stands in for a consul process, which is found by the data dir on its command line
+	agent := func(dataDir string) *exec.Cmd {
+		cmd := exec.Command("sh", "-c", "sleep 60; exit 0", "-data-dir", dataDir)
+		if err := cmd.Start(); err != nil {
+			t.Logf("Unable to start stand-in agent: %s", err)
+			t.FailNow()
+		}
+		return cmd
+	}
+
+	deadOwner := exec.Command("true")
+	if err = deadOwner.Run(); err != nil {
+		t.Logf("Unable to run stand-in owner: %s", err)
+		t.FailNow()
+	}
+	liveOwner := exec.Command("sleep", "60")
+	if err = liveOwner.Start(); err != nil {
+		t.Logf("Unable to start stand-in owner: %s", err)
+		t.FailNow()
+	}
+	defer liveOwner.Process.Kill()
+
+	orphanDir, ownedDir := filepath.Join(dir, "orphan"), filepath.Join(dir, "owned")
+	orphan, owned := agent(orphanDir), agent(ownedDir)
+	defer orphan.Process.Kill()
+	defer owned.Process.Kill()
+
+	state := fmt.Sprintf("%d %s\n%d %s\n", deadOwner.Process.Pid, orphanDir, liveOwner.Process.Pid, ownedDir)
+	if err = ioutil.WriteFile(agentman.StateFile, []byte(state), 0644); err != nil {
+		t.Logf("Unable to write state file: %s", err)
+		t.FailNow()
+	}
+
+	pids, err := agentman.FindOrphanedAgents()
+	if err != nil {
+		t.Logf("Unable to FindOrphanedAgents(): %s", err)
+		t.FailNow()
+	}
+	if len(pids) != 1 || pids[0] != orphan.Process.Pid {
+		t.Logf("Expected only agent %d to be orphaned, saw: %v", orphan.Process.Pid, pids)
+		t.FailNow()
+	}
+
+	if err = agentman.KillOrphans(); err != nil {
+		t.Logf("Unable to KillOrphans(): %s", err)
+		t.FailNow()
+	}
+	orphan.Wait()
+	if err = owned.Process.Signal(syscall.Signal(0)); err != nil {
+		t.Logf("Expected agent of a live owner to be left running, saw: %s", err)
+		t.FailNow()
+	}
+
+	b, err := ioutil.ReadFile(agentman.StateFile)
+	if err != nil {
+		t.Logf("Unable to read state file: %s", err)
+		t.FailNow()
+	}
+	if remaining := string(b); strings.Contains(remaining, orphanDir) || !strings.Contains(remaining, ownedDir) {
+		t.Logf("Expected only the entry of the live owner to remain, saw: %q", remaining)
+		t.FailNow()
+	}
+}
+
+func TestAgentManHooks(t *testing.T) {
+	am := agentman.NewAgentMan()
+
+	started := make([]string, 0)
+	stopped := make([]string, 0)
+
+	am.OnStart(func(name string) {
+		started = append(started, name)
+	})
+	am.OnStop(func(name string) {
+		stopped = append(stopped, name)
+	})
+
+	_, err := am.NewInstance(InstanceName1, shutup)
+	if err != nil {
+		t.Logf("Error during NewInstance(): %s", err)
+		t.FailNow()
+	}
+
+	err = am.StopInstance(InstanceName1)
+	if err != nil {
+		t.Logf("Error seen while stopping instance: %s", err)
+	}
+
+	if len(started) != 1 || started[0] != InstanceName1 {
+		t.Logf("Expected OnStart to see [%s], saw: %v", InstanceName1, started)
+		t.FailNow()
+	}
+	if len(stopped) != 1 || stopped[0] != InstanceName1 {
+		t.Logf("Expected OnStop to see [%s], saw: %v", InstanceName1, stopped)
+		t.FailNow()
+	}
+}
+
+func TestAgentManHooksCallIntoCluster(t *testing.T) {
+	am := agentman.NewAgentMan()
+	defer am.Stop()
+
+	cluster, err := am.NewCluster(ClusterName1, 3, quietCluster)
+	if err != nil {
+		t.Logf("Error during NewCluster(): %s", err)
+		t.FailNow()
+	}
+
+	// handlers run after the cluster lock is released, so calling back into the cluster must not deadlock
+	sizes := make([]int, 0)
+	am.OnStart(func(name string) {
+		sizes = append(sizes, cluster.Size())
+	})
+	am.OnStop(func(name string) {
+		sizes = append(sizes, cluster.Size())
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		if err := cluster.Grow(1, quietCluster); err != nil {
+			done <- err
+			return
+		}
+		done <- cluster.Shrink(1)
+	}()
+
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Logf("Unable to Grow() and Shrink(): %s", err)
+			t.FailNow()
+		}
+	case <-time.After(2 * time.Minute):
+		t.Log("Grow() and Shrink() did not return, hooks deadlocked calling back into the cluster")
+		t.FailNow()
+	}
+
+	if len(sizes) != 2 || sizes[0] != 4 || sizes[1] != 3 {
+		t.Logf("Expected hooks to see sizes [4 3], saw: %v", sizes)
+		t.FailNow()
+	}
+}
+
+func TestAgentManGetOrCreateInstance(t *testing.T) {
+	am := agentman.NewAgentMan()
+	defer am.Stop()
+
+	first, created, err := am.GetOrCreateInstance(InstanceName1, shutup)
+	if err != nil {
+		t.Logf("Error during GetOrCreateInstance(): %s", err)
+		t.FailNow()
+	}
+	if !created {
+		t.Log("Expected first call to create the instance")
+		t.FailNow()
+	}
+
+	second, created, err := am.GetOrCreateInstance(InstanceName1, shutup)
+	if err != nil {
+		t.Logf("Error during GetOrCreateInstance(): %s", err)
+		t.FailNow()
+	}
+	if created || !second.Same(first) {
+		t.Log("Expected second call to return the existing instance")
+		t.FailNow()
+	}
+
+	_, err = am.NewInstance(InstanceName1, shutup)
+	if !errors.Is(err, agentman.ErrInstanceExists) {
+		t.Logf("Expected NewInstance() with existing name to return ErrInstanceExists, saw: %v", err)
+		t.FailNow()
+	}
+
+	first.Stop()
+
+	third, created, err := am.GetOrCreateInstance(InstanceName1, shutup)
+	if err != nil {
+		t.Logf("Error during GetOrCreateInstance(): %s", err)
+		t.FailNow()
+	}
+	if !created || third.Stopped() {
+		t.Log("Expected defunct instance to be recreated")
+		t.FailNow()
+	}
+}
+
+func TestAgentManStopClusterMember(t *testing.T) {
+	am := agentman.NewAgentMan()
+	defer am.Stop()
+
+	cluster, err := am.NewCluster(ClusterName1, 1, quietCluster)
+	if err != nil {
+		t.Logf("Error during NewCluster(): %s", err)
+		t.FailNow()
+	}
+
+	err = am.StopInstance(agentman.ClusterInstanceName(ClusterName1, 0))
+	if !errors.Is(err, agentman.ErrInstanceOwnedByCluster) {
+		t.Logf("Expected StopInstance() of a cluster member to return ErrInstanceOwnedByCluster, saw: %v", err)
+		t.FailNow()
+	}
+	if cluster.Instance(0).Stopped() {
+		t.Log("Expected cluster member to still be running")
+		t.FailNow()
+	}
+
+	if names := am.ClusterNames(); len(names) != 1 || names[0] != ClusterName1 {
+		t.Logf("Expected ClusterNames() to return [%s], saw: %v", ClusterName1, names)
+		t.FailNow()
+	}
+
+	if err = am.StopInstance("not-" + InstanceName1); err != nil {
+		t.Logf("Expected StopInstance() of an unknown name to be a no-op, saw: %s", err)
+		t.FailNow()
+	}
+}
+
+func TestAgentManStartInterval(t *testing.T) {
+	const interval = 2 * time.Second
+
+	am := agentman.NewAgentMan()
+	defer am.Stop()
+	am.SetStartInterval(interval)
+
+	starts := make([]time.Time, 0)
+	for i := 0; i < 3; i++ {
+		_, err := am.NewInstance(fmt.Sprintf("%s-%d", InstanceName1, i), func(conf *testutil.TestServerConfig) {
+			shutup(conf)
+			starts = append(starts, time.Now())
+		})
+		if err != nil {
+			t.Logf("Error during NewInstance(): %s", err)
+			t.FailNow()
+		}
+	}
+
+	for i := 1; i < len(starts); i++ {
+		if gap := starts[i].Sub(starts[i-1]); gap < interval {
+			t.Logf("Expected startups at least %s apart, saw %s between %d and %d", interval, gap, i-1, i)
+			t.FailNow()
+		}
+	}
+}
+
+func TestAgentManExport(t *testing.T) {
+	am := agentman.NewAgentMan()
+	defer am.Stop()
+
+	if _, err := am.NewInstance(InstanceName1, shutup); err != nil {
+		t.Logf("Error during NewInstance(): %s", err)
+		t.FailNow()
+	}
+	if _, err := am.NewCluster(ClusterName1, 2, quietCluster); err != nil {
+		t.Logf("Error during NewCluster(): %s", err)
+		t.FailNow()
+	}
+
+	state := am.Export()
+	if len(state.Instances) != 1 || state.Instances[0].Name != InstanceName1 || state.Instances[0].HTTPAddr == "" {
+		t.Logf("Expected export of live instance %s, saw: %+v", InstanceName1, state.Instances)
+		t.FailNow()
+	}
+	if len(state.Clusters) != 1 || len(state.Clusters[0].Instances) != 2 {
+		t.Logf("Expected export of cluster %s with 2 instances, saw: %+v", ClusterName1, state.Clusters)
+		t.FailNow()
+	}
+
+	b, err := state.JSON()
+	if err != nil {
+		t.Logf("Unable to JSON(): %s", err)
+		t.FailNow()
+	}
+	var decoded agentman.ManagerState
+	if err = json.Unmarshal(b, &decoded); err != nil {
+		t.Logf("Unable to unmarshal exported state %s: %s", string(b), err)
+		t.FailNow()
+	}
+	if decoded.Clusters[0].Instances[1].Name != agentman.ClusterInstanceName(ClusterName1, 1) {
+		t.Logf("Unexpected round-tripped state: %+v", decoded)
+		t.FailNow()
+	}
+}
+
+func TestAgentManMaxConcurrency(t *testing.T) {
+	const limit = 2
+
+	am := agentman.NewAgentMan()
+	defer am.Stop()
+	am.SetMaxConcurrency(limit)
+
+	var m sync.Mutex
+	inFlight, peak := 0, 0
+	hold := false
+	track := agentman.WithPostStart(func(*agentman.TestInstance) error {
+		m.Lock()
+		inFlight++
+		if inFlight > peak {
+			peak = inFlight
+		}
+		m.Unlock()
+		// while growing, each startup is held open until the limit is reached so that running fewer at once is caught
+		for deadline := time.Now().Add(30 * time.Second); time.Now().Before(deadline); {
+			m.Lock()
+			waiting := hold && peak < limit
+			m.Unlock()
+			if !waiting {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		time.Sleep(500 * time.Millisecond)
+		m.Lock()
+		inFlight--
+		m.Unlock()
+		return nil
+	})
+
+	for i := 0; i < limit*2; i++ {
+		_, err := am.NewCluster(fmt.Sprintf("%s-%d", ClusterName1, i), 1, quietCluster, track)
+		if err != nil {
+			t.Logf("Error during NewCluster(): %s", err)
+			t.FailNow()
+		}
+	}
+
+	m.Lock()
+	peak = 0
+	hold = true
+	m.Unlock()
+
+	err := am.GrowAll(1, quietCluster)
+	if err != nil {
+		t.Logf("Error during GrowAll(): %s", err)
+		t.FailNow()
+	}
+
+	if peak > limit {
+		t.Logf("Expected at most %d concurrent instance startups, saw: %d", limit, peak)
+		t.FailNow()
+	}
+	if peak != limit {
+		t.Logf("Expected %d concurrent instance startups once the limit was reached, saw: %d", limit, peak)
+		t.FailNow()
 	}
 }
@@ -0,0 +1,25 @@
+package agentman
+
+import (
+	"github.com/hashicorp/consul/testutil"
+	"io/ioutil"
+)
+
+// BenchServerConfigCallback tunes a test server for benchmarking code that talks to consul: agent output is
+// discarded, only errors are logged, update checks are disabled, and raft runs at its fastest timings.
+var BenchServerConfigCallback testutil.ServerConfigCallback = func(conf *testutil.TestServerConfig) {
+	conf.Stdout = ioutil.Discard
+	conf.Stderr = ioutil.Discard
+	conf.LogLevel = "ERR"
+	conf.DisableCheckpoint = true
+	conf.Bootstrap = true
+	conf.Performance.RaftMultiplier = 1
+}
+
+// NewBenchInstance will attempt to create a test instance configured by BenchServerConfigCallback.  The fast raft
+// timings make leader elections quicker but also make the server more prone to spurious elections on a loaded host,
+// and with output discarded there is little to go on should it misbehave, so prefer NewTestInstance when not
+// benchmarking.
+func NewBenchInstance(name string) (*TestInstance, error) {
+	return NewTestInstance(name, BenchServerConfigCallback)
+}
@@ -0,0 +1,56 @@
+package agentman
+
+import (
+	"github.com/hashicorp/consul/api"
+)
+
+// CreateIntention will attempt to create an intention allowing, or denying, connections from service src to service
+// dst
+func (ti *TestInstance) CreateIntention(src, dst string, allow bool) error {
+	client, ok := ti.apiClient()
+	if !ok {
+		return ti.defunctErr()
+	}
+	action := api.IntentionActionDeny
+	if allow {
+		action = api.IntentionActionAllow
+	}
+	_, _, err := client.Connect().IntentionCreate(&api.Intention{
+		SourceName:      src,
+		DestinationName: dst,
+		SourceType:      api.IntentionSourceConsul,
+		Action:          action,
+	}, nil)
+	return err
+}
+
+// ListIntentions will attempt to retrieve every intention defined in the cluster
+func (ti *TestInstance) ListIntentions() ([]*api.Intention, error) {
+	client, ok := ti.apiClient()
+	if !ok {
+		return nil, ti.defunctErr()
+	}
+	intentions, _, err := client.Connect().Intentions(nil)
+	return intentions, err
+}
+
+// ConnectCAGetConfig will attempt to retrieve the configuration of the Connect CA, including which provider is in use
+func (ti *TestInstance) ConnectCAGetConfig() (*api.CAConfig, error) {
+	client, ok := ti.apiClient()
+	if !ok {
+		return nil, ti.defunctErr()
+	}
+	conf, _, err := client.Connect().CAGetConfig(nil)
+	return conf, err
+}
+
+// ConnectCASetConfig will attempt to update the configuration of the Connect CA.  Changing the provider, or the root
+// key of the built-in provider, triggers a root rotation.
+func (ti *TestInstance) ConnectCASetConfig(conf *api.CAConfig) error {
+	client, ok := ti.apiClient()
+	if !ok {
+		return ti.defunctErr()
+	}
+	_, err := client.Connect().CASetConfig(conf, nil)
+	return err
+}
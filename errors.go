@@ -0,0 +1,84 @@
+package agentman
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrInstanceDefunct is returned by error-returning TestInstance methods once the instance has been stopped
+var ErrInstanceDefunct = errors.New("instance is defunct")
+
+// ErrInstanceExists is returned when creating an instance under a name the manager already has registered
+var ErrInstanceExists = errors.New("instance already exists")
+
+// ErrClusterExists is returned when creating a cluster under a name the manager already has registered
+var ErrClusterExists = errors.New("cluster already exists")
+
+// ErrInstanceOwnedByCluster is returned when the manager is asked to act on a cluster member as though it were a single
+// instance.  Members are managed via their cluster.
+var ErrInstanceOwnedByCluster = errors.New("instance is owned by a cluster")
+
+// ErrStopTimeout is returned when an instance did not stop gracefully in time and its process had to be killed
+var ErrStopTimeout = errors.New("instance did not stop in time")
+
+// ErrBootstrapTimeout is returned when a new cluster did not elect a leader in time
+var ErrBootstrapTimeout = errors.New("cluster did not bootstrap in time")
+
+// ErrPortInUse is returned when a port set for an instance is already bound by another process
+var ErrPortInUse = errors.New("port is already in use")
+
+// Phases of instance startup reported by InstanceStartError
+const (
+	PhaseServerStart  = "server-start"
+	PhaseClientCreate = "client-create"
+	PhaseJoin         = "join"
+	PhasePostStart    = "post-start"
+)
+
+// InstanceStartError is returned when an instance fails to come up.  Phase will be one of the Phase* constants,
+// allowing callers to branch on where in the startup process the failure occurred.
+type InstanceStartError struct {
+	Name  string
+	Phase string
+	Err   error
+}
+
+func (e *InstanceStartError) Error() string {
+	return fmt.Sprintf("instance \"%s\" failed during %s: %s", e.Name, e.Phase, e.Err)
+}
+
+func (e *InstanceStartError) Unwrap() error {
+	return e.Err
+}
+
+// ClusterStartError is returned when a cluster fails to come up.  Started is the number of instances which were up
+// before instance Failed could not be started.  All started instances will have been stopped.
+type ClusterStartError struct {
+	Name    string
+	Size    uint8
+	Started int
+	Failed  uint8
+	Err     error
+}
+
+func (e *ClusterStartError) Error() string {
+	return fmt.Sprintf("cluster \"%s\" failed to start instance %d after %d of %d instances were up: %s", e.Name, e.Failed, e.Started, e.Size, e.Err)
+}
+
+func (e *ClusterStartError) Unwrap() error {
+	return e.Err
+}
+
+// VoterTimeoutError is returned when instances added to a cluster were not promoted to raft voters in time.  Pending
+// holds the names of those instances.
+type VoterTimeoutError struct {
+	Name    string
+	Timeout time.Duration
+	Pending []string
+}
+
+func (e *VoterTimeoutError) Error() string {
+	return fmt.Sprintf("cluster \"%s\" instances [\"%s\"] were not promoted to voters within %s", e.Name, strings.Join(e.Pending, "\", \""), e.Timeout)
+}
@@ -0,0 +1,104 @@
+package agentman
+
+import (
+	"encoding/json"
+	"net"
+	"sort"
+	"strconv"
+)
+
+type (
+	// InstanceInfo describes a test instance at the time it was exported.  Addresses are empty once the instance has
+	// stopped.
+	InstanceInfo struct {
+		Name       string `json:"name"`
+		NodeName   string `json:"node_name"`
+		NodeID     string `json:"node_id"`
+		Datacenter string `json:"datacenter"`
+		DataDir    string `json:"data_dir"`
+		HTTPAddr   string `json:"http_addr,omitempty"`
+		LANAddr    string `json:"lan_addr,omitempty"`
+		WANAddr    string `json:"wan_addr,omitempty"`
+		Stopped    bool   `json:"stopped"`
+	}
+
+	// ClusterInfo describes a test cluster and each of its members at the time it was exported
+	ClusterInfo struct {
+		Name      string         `json:"name"`
+		Stopped   bool           `json:"stopped"`
+		Instances []InstanceInfo `json:"instances"`
+	}
+
+	// ManagerState is a serializable snapshot of every single instance and cluster registered with a manager
+	ManagerState struct {
+		Instances []InstanceInfo `json:"instances"`
+		Clusters  []ClusterInfo  `json:"clusters"`
+	}
+)
+
+// Info describes the current state of this instance
+func (ti *TestInstance) Info() InstanceInfo {
+	ti.m.Lock()
+	defer ti.m.Unlock()
+
+	info := InstanceInfo{
+		Name:    ti.name,
+		Stopped: ti.server == nil,
+	}
+	if ti.conf != nil {
+		info.NodeName = ti.conf.NodeName
+		info.NodeID = ti.conf.NodeID
+		info.Datacenter = ti.conf.Datacenter
+		info.DataDir = ti.conf.DataDir
+	}
+	if ti.server != nil {
+		info.HTTPAddr = httpHostPort(ti.server.Config, ti.server.Config.Ports.HTTP)
+		info.LANAddr = net.JoinHostPort(ti.server.Config.Bind, strconv.Itoa(ti.server.Config.Ports.SerfLan))
+		info.WANAddr = net.JoinHostPort(ti.server.Config.Bind, strconv.Itoa(ti.server.Config.Ports.SerfWan))
+	}
+	return info
+}
+
+// Info describes the current state of this cluster and each of its members, including any stopped in place
+func (cl *TestCluster) Info() ClusterInfo {
+	cl.m.Lock()
+	defer cl.m.Unlock()
+
+	info := ClusterInfo{
+		Name:      cl.name,
+		Stopped:   cl.stopped,
+		Instances: make([]InstanceInfo, len(cl.instances)),
+	}
+	for i, instance := range cl.instances {
+		info.Instances[i] = instance.Info()
+	}
+	return info
+}
+
+// Export captures the state of every single instance and cluster registered with this manager, each ordered by name
+func (am *AgentMan) Export() ManagerState {
+	state := ManagerState{
+		Instances: make([]InstanceInfo, 0),
+		Clusters:  make([]ClusterInfo, 0),
+	}
+	for _, instance := range am.instanceList() {
+		state.Instances = append(state.Instances, instance.Info())
+	}
+	for _, cluster := range am.clusterList() {
+		state.Clusters = append(state.Clusters, cluster.Info())
+	}
+
+	sort.Slice(state.Instances, func(i, j int) bool {
+		return state.Instances[i].Name < state.Instances[j].Name
+	})
+	sort.Slice(state.Clusters, func(i, j int) bool {
+		return state.Clusters[i].Name < state.Clusters[j].Name
+	})
+
+	return state
+}
+
+// JSON marshals the state, in a form suited to being written to a file
+func (s ManagerState) JSON() ([]byte, error) {
+	return json.MarshalIndent(s, "", "  ")
+}
@@ -0,0 +1,218 @@
+package agentman
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MemberPollInterval is how often membership is polled by operations which watch for changes to it
+var MemberPollInterval = 250 * time.Millisecond
+
+// MeasureConvergence will fire a user event from one live member and time how long it takes for every live member to
+// observe it.  As user events are propagated via gossip, this gives a measure of gossip convergence across the
+// cluster.  An error is returned if not all members have seen the event within the timeout.
+func (cl *TestCluster) MeasureConvergence(timeout time.Duration) (time.Duration, error) {
+	cl.m.Lock()
+	defer cl.m.Unlock()
+	if cl.stopped {
+		return 0, fmt.Errorf("cluster \"%s\" is defunct", cl.name)
+	}
+
+	live := cl.liveInstances()
+	pending := make(map[string]*api.Client, len(live))
+	var firer *TestInstance
+	var firing *api.Client
+	for _, instance := range live {
+		if client, ok := instance.apiClient(); ok {
+			pending[instance.Name()] = client
+			if firer == nil {
+				firer, firing = instance, client
+			}
+		}
+	}
+	if len(pending) == 0 {
+		return 0, fmt.Errorf("cluster \"%s\" has no live instances", cl.name)
+	}
+
+	name := fmt.Sprintf("agentman-convergence-%d", time.Now().UnixNano())
+
+	start := time.Now()
+	id, _, err := firing.Event().Fire(&api.UserEvent{Name: name}, nil)
+	if err != nil {
+		return 0, fmt.Errorf("unable to fire convergence event from instance \"%s\": %s", firer.Name(), err)
+	}
+
+	deadline := start.Add(timeout)
+	for {
+		for instanceName, client := range pending {
+			events, _, err := client.Event().List(name, nil)
+			if err != nil {
+				continue
+			}
+			for _, event := range events {
+				if event.ID == id {
+					delete(pending, instanceName)
+					break
+				}
+			}
+		}
+
+		if len(pending) == 0 {
+			return time.Since(start), nil
+		}
+
+		if time.Now().After(deadline) {
+			names := make([]string, 0, len(pending))
+			for instanceName := range pending {
+				names = append(names, instanceName)
+			}
+			return 0, fmt.Errorf("cluster \"%s\" did not converge within %s, instances yet to observe event: [\"%s\"]", cl.name, timeout, strings.Join(names, "\", \""))
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// WatchMembers will poll the gossip member list of the cluster, via whichever member is live at the time, emitting
+// the full membership each time it changes until ctx is cancelled.  The current membership is always emitted first.
+// The returned channel is closed once watching ends.
+func (cl *TestCluster) WatchMembers(ctx context.Context) (<-chan []*api.AgentMember, error) {
+	if _, err := cl.members(); err != nil {
+		return nil, err
+	}
+
+	ch := make(chan []*api.AgentMember)
+
+	go func() {
+		defer close(ch)
+
+		var last string
+
+		ticker := time.NewTicker(MemberPollInterval)
+		defer ticker.Stop()
+
+		for {
+			if members, err := cl.members(); err == nil {
+				if sig := memberSignature(members); sig != last {
+					select {
+					case ch <- members:
+						last = sig
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// memberStatuses names the serf member status codes reported by the agent members endpoint
+var memberStatuses = []string{"none", "alive", "leaving", "left", "failed"}
+
+// WaitForSize will poll the gossip member list of the cluster until exactly expected members are alive or the timeout
+// elapses.  On timeout, the returned error names each member not alive along with its status.
+func (cl *TestCluster) WaitForSize(expected int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		members, err := cl.members()
+		if err != nil {
+			return err
+		}
+
+		alive := 0
+		transitioning := make([]string, 0)
+		for _, member := range members {
+			if member.Status == 1 {
+				alive++
+				continue
+			}
+			status := "unknown"
+			if member.Status >= 0 && member.Status < len(memberStatuses) {
+				status = memberStatuses[member.Status]
+			}
+			transitioning = append(transitioning, fmt.Sprintf("%s (%s)", member.Name, status))
+		}
+
+		if alive == expected {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			sort.Strings(transitioning)
+			return fmt.Errorf("cluster \"%s\" had %d alive members rather than %d after %s, members not alive: [%s]", cl.name, alive, expected, timeout, strings.Join(transitioning, ", "))
+		}
+
+		time.Sleep(MemberPollInterval)
+	}
+}
+
+// MemberCounts will summarise how gossip perceives the cluster, counting the alive, failed, and left members of the
+// LAN member list as seen by the first live instance able to answer.  Members still joining or leaving are not
+// counted.
+func (cl *TestCluster) MemberCounts() (alive, failed, left int, err error) {
+	members, err := cl.members()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	for _, member := range members {
+		// indexes into memberStatuses
+		switch member.Status {
+		case 1:
+			alive++
+		case 3:
+			left++
+		case 4:
+			failed++
+		}
+	}
+	return alive, failed, left, nil
+}
+
+// members returns the gossip LAN member list as seen by the first live instance able to answer
+func (cl *TestCluster) members() ([]*api.AgentMember, error) {
+	cl.m.Lock()
+	defer cl.m.Unlock()
+	if cl.stopped {
+		return nil, fmt.Errorf("cluster \"%s\" is defunct", cl.name)
+	}
+
+	errs := NewMultiErr()
+	for _, instance := range cl.instances {
+		client, ok := instance.apiClient()
+		if !ok {
+			continue
+		}
+		members, err := client.Agent().Members(false)
+		if err == nil {
+			return members, nil
+		}
+		errs.Add(fmt.Errorf("instance \"%s\": %s", instance.Name(), err))
+	}
+
+	if errs.Size() == 0 {
+		return nil, fmt.Errorf("cluster \"%s\" has no live instances", cl.name)
+	}
+	return nil, fmt.Errorf("no instance in cluster \"%s\" could be reached: %s", cl.name, errs)
+}
+
+// memberSignature summarises the identity and status of each member, for detecting changes in membership
+func memberSignature(members []*api.AgentMember) string {
+	parts := make([]string, len(members))
+	for i, member := range members {
+		parts[i] = fmt.Sprintf("%s/%s:%d/%d", member.Name, member.Addr, member.Port, member.Status)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,286 @@
+package agentman
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Agent token types accepted by SetAgentToken
+const (
+	AgentTokenDefault     = "acl_token"
+	AgentTokenAgent       = "acl_agent_token"
+	AgentTokenAgentMaster = "acl_agent_master_token"
+	AgentTokenReplication = "acl_replication_token"
+)
+
+// SetAgentToken will attempt to update one of the ACL tokens used by the agent at runtime.  tokenType must be one of
+// the AgentToken* constants.  The update is authorized with the server's ACL master token, if one is configured.
+func (ti *TestInstance) SetAgentToken(tokenType, token string) error {
+	ti.m.Lock()
+	if ti.server == nil {
+		ti.m.Unlock()
+		return ti.defunctErr()
+	}
+	client := ti.client
+	q := &api.WriteOptions{Token: ti.server.Config.ACLMasterToken}
+	ti.m.Unlock()
+
+	var err error
+	switch tokenType {
+	case AgentTokenDefault:
+		_, err = client.Agent().UpdateACLToken(token, q)
+	case AgentTokenAgent:
+		_, err = client.Agent().UpdateACLAgentToken(token, q)
+	case AgentTokenAgentMaster:
+		_, err = client.Agent().UpdateACLAgentMasterToken(token, q)
+	case AgentTokenReplication:
+		_, err = client.Agent().UpdateACLReplicationToken(token, q)
+	default:
+		return fmt.Errorf("unknown agent token type \"%s\"", tokenType)
+	}
+
+	return err
+}
+
+// CatalogDeregisterNode will attempt to remove a node, along with all of its services and checks, from the catalog
+func (ti *TestInstance) CatalogDeregisterNode(node string) error {
+	return ti.CatalogDeregisterNodeOpts(node, nil)
+}
+
+// CatalogDeregisterNodeOpts is CatalogDeregisterNode with write options, allowing the request to be scoped to a
+// particular datacenter or token.  nil options target the defaults of the agent.
+func (ti *TestInstance) CatalogDeregisterNodeOpts(node string, w *api.WriteOptions) error {
+	client, ok := ti.apiClient()
+	if !ok {
+		return ti.defunctErr()
+	}
+	_, err := client.Catalog().Deregister(&api.CatalogDeregistration{Node: node}, w)
+	return err
+}
+
+// HTTPDo will execute a raw request against this instance's HTTP API.  path is relative to the instance address,
+// e.g. "/v1/agent/self".
+func (ti *TestInstance) HTTPDo(method, path string, body io.Reader) (*http.Response, error) {
+	scheme, hostport, err := ti.Addr()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(method, fmt.Sprintf("%s://%s/%s", scheme, hostport, strings.TrimPrefix(path, "/")), body)
+	if err != nil {
+		return nil, err
+	}
+
+	ti.m.Lock()
+	if ti.server == nil {
+		ti.m.Unlock()
+		return nil, ti.defunctErr()
+	}
+	client := ti.server.HTTPClient
+	ti.m.Unlock()
+
+	return client.Do(req)
+}
+
+// TxnKV will attempt to execute ops as a single atomic KV transaction.  The returned bool reports whether the
+// transaction was committed; when it was rolled back the response lists the errors encountered.
+func (ti *TestInstance) TxnKV(ops api.KVTxnOps) (bool, *api.KVTxnResponse, error) {
+	return ti.TxnKVOpts(ops, nil)
+}
+
+// TxnKVOpts is TxnKV with query options, allowing the transaction to be scoped to a particular datacenter or token.
+// nil options target the defaults of the agent.
+func (ti *TestInstance) TxnKVOpts(ops api.KVTxnOps, q *api.QueryOptions) (bool, *api.KVTxnResponse, error) {
+	client, ok := ti.apiClient()
+	if !ok {
+		return false, nil, ti.defunctErr()
+	}
+	committed, resp, _, err := client.KV().Txn(ops, q)
+	return committed, resp, err
+}
+
+// DumpKV will attempt to retrieve every key under prefix along with its value, for logging the KV state on a failed
+// assertion.  An empty prefix dumps the entire tree.
+func (ti *TestInstance) DumpKV(prefix string) (map[string][]byte, error) {
+	client, ok := ti.apiClient()
+	if !ok {
+		return nil, ti.defunctErr()
+	}
+	return dumpKV(client, prefix)
+}
+
+// DumpKV is TestInstance.DumpKV performed against the current leader of the cluster, so the result is never stale
+func (cl *TestCluster) DumpKV(prefix string) (map[string][]byte, error) {
+	client, err := cl.LeaderClient()
+	if err != nil {
+		return nil, err
+	}
+	return dumpKV(client, prefix)
+}
+
+func dumpKV(client *api.Client, prefix string) (map[string][]byte, error) {
+	pairs, _, err := client.KV().List(prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+	kv := make(map[string][]byte, len(pairs))
+	for _, pair := range pairs {
+		kv[pair.Key] = pair.Value
+	}
+	return kv, nil
+}
+
+// ServiceHealth will attempt to retrieve the health entries of every instance of service, optionally limited to those
+// with all checks passing
+func (ti *TestInstance) ServiceHealth(service string, passingOnly bool) ([]*api.ServiceEntry, error) {
+	return ti.ServiceHealthOpts(service, passingOnly, nil)
+}
+
+// ServiceHealthOpts is ServiceHealth with query options, allowing the lookup to be scoped to a particular datacenter
+// or token.  nil options target the defaults of the agent.
+func (ti *TestInstance) ServiceHealthOpts(service string, passingOnly bool, q *api.QueryOptions) ([]*api.ServiceEntry, error) {
+	client, ok := ti.apiClient()
+	if !ok {
+		return nil, ti.defunctErr()
+	}
+	entries, _, err := client.Health().Service(service, "", passingOnly, q)
+	return entries, err
+}
+
+// ServiceAllDCs will attempt to retrieve the health entries of every instance of service in each datacenter this
+// instance knows of, keyed by datacenter.  Should any datacenter fail to answer, the entries of those which did are
+// returned alongside a *MultiErr describing the failures.
+func (ti *TestInstance) ServiceAllDCs(service string) (map[string][]*api.ServiceEntry, error) {
+	client, ok := ti.apiClient()
+	if !ok {
+		return nil, ti.defunctErr()
+	}
+
+	dcs, err := client.Catalog().Datacenters()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string][]*api.ServiceEntry, len(dcs))
+	errs := NewMultiErr()
+	for _, dc := range dcs {
+		dcEntries, _, err := client.Health().Service(service, "", false, &api.QueryOptions{Datacenter: dc})
+		if err != nil {
+			errs.Add(fmt.Errorf("datacenter \"%s\": %s", dc, err))
+			continue
+		}
+		entries[dc] = dcEntries
+	}
+
+	if errs.Size() > 0 {
+		return entries, errs
+	}
+	return entries, nil
+}
+
+// CatalogNode will attempt to retrieve this instance's own entry from the catalog, including its node metadata and
+// registered services
+func (ti *TestInstance) CatalogNode() (*api.CatalogNode, error) {
+	ti.m.Lock()
+	if ti.server == nil {
+		ti.m.Unlock()
+		return nil, ti.defunctErr()
+	}
+	client, nodeName := ti.client, ti.server.Config.NodeName
+	ti.m.Unlock()
+
+	node, _, err := client.Catalog().Node(nodeName, nil)
+	return node, err
+}
+
+// IsLeader will attempt to determine whether this instance currently holds raft leadership, by comparing the leader
+// address it reports against its own raft address
+func (ti *TestInstance) IsLeader() (bool, error) {
+	client, ok := ti.apiClient()
+	if !ok {
+		return false, ti.defunctErr()
+	}
+	addr, ok := ti.raftAddr()
+	if !ok {
+		return false, ti.defunctErr()
+	}
+	leader, err := client.Status().Leader()
+	if err != nil {
+		return false, err
+	}
+	return leader == addr, nil
+}
+
+// FireEvent will attempt to fire a user event with the given name and payload, returning the ID of the event
+func (ti *TestInstance) FireEvent(name string, payload []byte) (string, error) {
+	client, ok := ti.apiClient()
+	if !ok {
+		return "", ti.defunctErr()
+	}
+	id, _, err := client.Event().Fire(&api.UserEvent{Name: name, Payload: payload}, nil)
+	return id, err
+}
+
+// ListEvents will attempt to retrieve the recent user events with the given name this instance has received.  An empty
+// name lists events of all names.
+func (ti *TestInstance) ListEvents(name string) ([]*api.UserEvent, error) {
+	client, ok := ti.apiClient()
+	if !ok {
+		return nil, ti.defunctErr()
+	}
+	events, _, err := client.Event().List(name, nil)
+	return events, err
+}
+
+// Ping will attempt a minimal request against the agent, returning nil if it responded.  It is cheap enough to be
+// polled frequently.
+func (ti *TestInstance) Ping() error {
+	client, ok := ti.apiClient()
+	if !ok {
+		return ti.defunctErr()
+	}
+	if _, err := client.Status().Leader(); err != nil {
+		return fmt.Errorf("instance \"%s\" did not respond: %s", ti.name, err)
+	}
+	return nil
+}
+
+// WaitForServer will block until this instance reports itself as a server with raft initialized, as shown by it
+// answering for its raft configuration, or the timeout elapses.  Unlike the agent merely answering API calls, this
+// shows the instance is ready to take part in forming quorum.  The configuration is read with a stale query, so no
+// leader need have been elected.
+func (ti *TestInstance) WaitForServer(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := ti.serverReady()
+		if err == nil || errors.Is(err, ErrInstanceDefunct) {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("instance \"%s\" was not ready as a server within %s: %s", ti.name, timeout, err)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// serverReady returns nil once this instance is a server able to answer for its raft configuration
+func (ti *TestInstance) serverReady() error {
+	client, ok := ti.apiClient()
+	if !ok {
+		return ti.defunctErr()
+	}
+	self, err := client.Agent().Self()
+	if err != nil {
+		return err
+	}
+	if server, _ := self["Config"]["Server"].(bool); !server {
+		return errors.New("agent is not running as a server")
+	}
+	_, err = client.Operator().RaftGetConfiguration(&api.QueryOptions{AllowStale: true})
+	return err
+}
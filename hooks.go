@@ -0,0 +1,95 @@
+package agentman
+
+import (
+	"sync"
+)
+
+// lifecycleHooks holds the handlers registered to observe instances starting and stopping.  A nil *lifecycleHooks is
+// valid and notifies no one.
+type lifecycleHooks struct {
+	m       sync.RWMutex
+	onStart []func(name string)
+	onStop  []func(name string)
+}
+
+func (h *lifecycleHooks) addStart(fn func(name string)) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	h.onStart = append(h.onStart, fn)
+}
+
+func (h *lifecycleHooks) addStop(fn func(name string)) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	h.onStop = append(h.onStop, fn)
+}
+
+func (h *lifecycleHooks) started(name string) {
+	if h == nil {
+		return
+	}
+	h.m.RLock()
+	fns := h.onStart
+	h.m.RUnlock()
+	for _, fn := range fns {
+		fn(name)
+	}
+}
+
+func (h *lifecycleHooks) stopped(name string) {
+	if h == nil {
+		return
+	}
+	h.m.RLock()
+	fns := h.onStop
+	h.m.RUnlock()
+	for _, fn := range fns {
+		fn(name)
+	}
+}
+
+// hookEvent is an instance starting or stopping, awaiting notification of the handlers
+type hookEvent struct {
+	name    string
+	started bool
+}
+
+// hookQueue collects the events raised while a lock is held so that handlers may be notified once it has been
+// released, sparing them from deadlocking should they call back into whatever raised the event.  Events may be queued
+// from multiple goroutines.
+type hookQueue struct {
+	m      sync.Mutex
+	events []hookEvent
+}
+
+func (q *hookQueue) started(name string) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	q.events = append(q.events, hookEvent{name: name, started: true})
+}
+
+func (q *hookQueue) stopped(name string) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	q.events = append(q.events, hookEvent{name: name})
+}
+
+// take empties the queue, returning the events it held in the order they were raised
+func (q *hookQueue) take() []hookEvent {
+	q.m.Lock()
+	defer q.m.Unlock()
+	events := q.events
+	q.events = nil
+	return events
+}
+
+// fire notifies handlers of each event in order
+func (h *lifecycleHooks) fire(events []hookEvent) {
+	for _, event := range events {
+		if event.started {
+			h.started(event.name)
+		} else {
+			h.stopped(event.name)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package agentman
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxCapturedLogBytes caps how much agent output each instance retains for Logs.  Once exceeded, the oldest output is
+// discarded.
+var MaxCapturedLogBytes = 1 << 20
+
+// consulLogTimeFormat is the timestamp consul prefixes each log line with
+const consulLogTimeFormat = "2006/01/02 15:04:05"
+
+// logBuffer retains the most recent output written to it, up to MaxCapturedLogBytes
+type logBuffer struct {
+	m   sync.Mutex
+	buf []byte
+}
+
+func (l *logBuffer) Write(p []byte) (int, error) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.buf = append(l.buf, p...)
+	if over := len(l.buf) - MaxCapturedLogBytes; over > 0 {
+		// drop through the end of the line containing the cut, so only whole lines are kept
+		cut := over
+		if i := bytes.IndexByte(l.buf[over:], '\n'); i >= 0 {
+			cut += i + 1
+		}
+		l.buf = append(l.buf[:0], l.buf[cut:]...)
+	}
+	return len(p), nil
+}
+
+func (l *logBuffer) String() string {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return string(l.buf)
+}
+
+// captureOutput tees agent output bound for w, or for stdout as testutil would default to, into logs
+func captureOutput(w io.Writer, logs *logBuffer) io.Writer {
+	if w == nil {
+		w = os.Stdout
+	}
+	return io.MultiWriter(w, logs)
+}
+
+// Logs returns the most recent output of the consul agent, up to MaxCapturedLogBytes.  Output is captured in addition
+// to being written wherever the config callback directed it, and remains available once the instance has stopped.
+func (ti *TestInstance) Logs() string {
+	return ti.logs.String()
+}
+
+// Logs returns the captured output of each live member of the cluster, keyed by instance name
+func (cl *TestCluster) Logs() map[string]string {
+	cl.m.Lock()
+	defer cl.m.Unlock()
+	logs := make(map[string]string, len(cl.instances))
+	for _, instance := range cl.liveInstances() {
+		logs[instance.Name()] = instance.Logs()
+	}
+	return logs
+}
+
+// DumpLogs writes the captured output of every live member of the cluster to w as a single stream, each line prefixed
+// with the name of its instance.  Lines are ordered by the timestamp consul logged them with, lines without one
+// staying with the line preceding them.
+func (cl *TestCluster) DumpLogs(w io.Writer) error {
+	type entry struct {
+		at   time.Time
+		name string
+		line string
+	}
+
+	entries := make([]entry, 0)
+	for name, logs := range cl.Logs() {
+		var at time.Time
+		for _, line := range strings.Split(strings.TrimRight(logs, "\n"), "\n") {
+			if line == "" {
+				continue
+			}
+			if t, ok := consulLogTime(line); ok {
+				at = t
+			}
+			entries = append(entries, entry{at: at, name: name, line: line})
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].at.Equal(entries[j].at) {
+			return entries[i].name < entries[j].name
+		}
+		return entries[i].at.Before(entries[j].at)
+	})
+
+	for _, e := range entries {
+		if _, err := fmt.Fprintf(w, "[%s] %s\n", e.name, e.line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// consulLogTime parses the timestamp at the start of a consul log line
+func consulLogTime(line string) (time.Time, bool) {
+	line = strings.TrimLeft(line, " \t")
+	if len(line) < len(consulLogTimeFormat) {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(consulLogTimeFormat, line[:len(consulLogTimeFormat)])
+	return t, err == nil
+}
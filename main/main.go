@@ -8,9 +8,13 @@ import (
 	"github.com/dcarbone/agentman"
 	"github.com/hashicorp/consul/testutil"
 	"github.com/steakknife/devnull"
+	"io"
+	"io/ioutil"
 	stdlog "log"
+	"math"
 	"os"
 	"os/signal"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
@@ -19,6 +23,10 @@ import (
 var (
 	quietFlag bool
 	debugFlag bool
+	jsonFlag  bool
+
+	verboseAgentsFlag bool
+	configFlag        string
 
 	cmdFlags          = flag.NewFlagSet("command", flag.ContinueOnError)
 	cmdFlagName       string
@@ -29,10 +37,17 @@ var (
 	cmdFlagShrink     bool
 	cmdFlagSize       uint
 	cmdFlagDumpConfig bool
+	cmdFlagPlan       bool
+	cmdFlagList       bool
 
 	am = agentman.NewAgentMan()
 
 	cmdLock = new(sync.Mutex)
+
+	// instanceDefaults are applied to every instance created, as loaded from -config.  Guarded by cmdLock.
+	instanceDefaults []agentman.Option
+	// nodeMetaDefaults is the node meta given to every instance created, as loaded from -config.  Guarded by cmdLock.
+	nodeMetaDefaults map[string]string
 )
 
 func log(d bool, v ...interface{}) {
@@ -55,6 +70,74 @@ func logf(d bool, format string, v ...interface{}) {
 	stdlog.Printf(format, v...)
 }
 
+// agentOutput routes the output of spawned consul agents to the daemon's own stdout and stderr when
+// -verbose-agents is set, otherwise discarding it
+func agentOutput(conf *testutil.TestServerConfig) {
+	if verboseAgentsFlag {
+		conf.Stdout = os.Stdout
+		conf.Stderr = os.Stderr
+	} else {
+		conf.Stdout = devnull.Writer
+		conf.Stderr = devnull.Writer
+	}
+}
+
+// instanceConfig configures instances created by commands, applying the node meta loaded from -config and routing
+// their output.  Caller must hold cmdLock.
+func instanceConfig(conf *testutil.TestServerConfig) {
+	agentOutput(conf)
+	if len(nodeMetaDefaults) == 0 {
+		return
+	}
+	if conf.NodeMeta == nil {
+		conf.NodeMeta = make(map[string]string, len(nodeMetaDefaults))
+	}
+	for k, v := range nodeMetaDefaults {
+		conf.NodeMeta[k] = v
+	}
+}
+
+// daemonConfig is the format of the file provided with -config
+type daemonConfig struct {
+	LogLevel    string            `json:"log_level"`
+	ExtraConfig json.RawMessage   `json:"extra_config"`
+	NodeMeta    map[string]string `json:"node_meta"`
+}
+
+// loadConfig reads -config, replacing the defaults applied to instances created from then on.  Should the file be
+// unreadable or invalid, the current defaults are kept.  Values consul would reject, such as an unknown log level, are
+// reported by the commands creating instances.
+func loadConfig() error {
+	if configFlag == "" {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(configFlag)
+	if err != nil {
+		return fmt.Errorf("unable to read config file \"%s\": %s", configFlag, err)
+	}
+
+	var conf daemonConfig
+	if err = json.Unmarshal(b, &conf); err != nil {
+		return fmt.Errorf("unable to parse config file \"%s\": %s", configFlag, err)
+	}
+
+	opts := make([]agentman.Option, 0)
+	if conf.LogLevel != "" {
+		opts = append(opts, agentman.WithLogLevel(conf.LogLevel))
+	}
+	if len(conf.ExtraConfig) > 0 && string(conf.ExtraConfig) != "null" {
+		opts = append(opts, agentman.WithExtraConfig(string(conf.ExtraConfig)))
+	}
+
+	cmdLock.Lock()
+	instanceDefaults = opts
+	nodeMetaDefaults = conf.NodeMeta
+	cmdLock.Unlock()
+
+	return nil
+}
+
 func instanceCommand() {
 	if cmdFlagCluster {
 		fmt.Fprint(os.Stdout, "Cannot specify -instance and -cluster at the same time\n")
@@ -65,64 +148,237 @@ func instanceCommand() {
 	} else if cmdFlagSize != 0 {
 		fmt.Fprint(os.Stdout, "-size not usable with -instance\n")
 	} else if cmdFlagStop {
-		am.StopInstance(cmdFlagName)
+		if _, ok := am.Instance(cmdFlagName); !ok {
+			fmt.Fprintf(os.Stdout, "Instance \"%s\" does not exist\n", cmdFlagName)
+		} else if err := am.StopInstance(cmdFlagName); err != nil {
+			fmt.Fprintf(os.Stdout, "Unable to stop instance: %s\n", err)
+		} else {
+			fmt.Fprintf(os.Stdout, "Stopped instance \"%s\"\n", cmdFlagName)
+		}
 	} else if cmdFlagDumpConfig {
 		inst, ok := am.Instance(cmdFlagName)
-		if ok {
-			// TODO: this is a bad idea....
-			b, _ := json.Marshal(inst.Config())
-			fmt.Fprintf(os.Stdout, "%s\n", string(b))
-		} else {
+		if !ok {
 			fmt.Fprint(os.Stdout, "{}\n")
+			return
+		}
+		b, err := inst.ConfigJSON()
+		if err != nil {
+			fmt.Fprintf(os.Stdout, "Unable to dump config: %s\n", err)
+			return
 		}
+		fmt.Fprintf(os.Stdout, "%s\n", string(b))
 	} else {
-		inst, err := am.NewInstance(cmdFlagName, func(conf *testutil.TestServerConfig) {
-			conf.Stdout = devnull.Writer
-			conf.Stderr = devnull.Writer
-		})
+		inst, err := am.NewInstance(cmdFlagName, instanceConfig, instanceDefaults...)
 		if err != nil {
 			fmt.Fprintf(os.Stdout, "Unable to start instance: %s\n", err)
 			return
 		}
-		b, _ := json.Marshal(inst.Config())
+		b, _ := inst.ConfigJSON()
 		fmt.Fprintf(os.Stdout, "%s\n", string(b))
 	}
 }
 
+type planEntry struct {
+	Name      string `json:"name"`
+	Bootstrap bool   `json:"bootstrap"`
+}
+
+// planCluster prints the instances that would be created for cluster -name of -size without starting anything.
+// Ports are chosen at random as each instance starts, and so cannot be reported ahead of time.
+func planCluster() {
+	if cmdFlagSize == 0 || cmdFlagSize > math.MaxUint8 {
+		fmt.Fprintf(os.Stdout, "-size must be between 1 and %d\n", math.MaxUint8)
+		return
+	}
+
+	plan := make([]planEntry, cmdFlagSize)
+	for i := range plan {
+		plan[i] = planEntry{
+			Name:      agentman.ClusterInstanceName(cmdFlagName, uint8(i)),
+			Bootstrap: i == 0,
+		}
+	}
+
+	if jsonFlag {
+		b, _ := json.Marshal(plan)
+		fmt.Fprintf(os.Stdout, "%s\n", string(b))
+		return
+	}
+
+	for _, entry := range plan {
+		if entry.Bootstrap {
+			fmt.Fprintf(os.Stdout, "%s (bootstrap)\n", entry.Name)
+		} else {
+			fmt.Fprintf(os.Stdout, "%s\n", entry.Name)
+		}
+	}
+}
+
+// clusterAgentOutput is instanceConfig for cluster members, which are otherwise configured by the default cluster
+// callback
+func clusterAgentOutput(name string, num uint8, conf *testutil.TestServerConfig) {
+	agentman.DefaultClusterServerConfigCallback(name, num, conf)
+	instanceConfig(conf)
+}
+
+type resizeResult struct {
+	Name string `json:"name"`
+	Size int    `json:"size"`
+}
+
+// resizeCluster grows or shrinks cluster -name by -size, printing the size it ends up at
+func resizeCluster() {
+	if cmdFlagSize == 0 || cmdFlagSize > math.MaxUint8 {
+		fmt.Fprintf(os.Stdout, "-size must be between 1 and %d\n", math.MaxUint8)
+		return
+	}
+
+	cluster, ok := am.Cluster(cmdFlagName)
+	if !ok {
+		fmt.Fprintf(os.Stdout, "Cluster \"%s\" does not exist\n", cmdFlagName)
+		return
+	}
+
+	var err error
+	if cmdFlagGrow {
+		err = cluster.Grow(uint8(cmdFlagSize), clusterAgentOutput, instanceDefaults...)
+	} else {
+		err = cluster.Shrink(uint8(cmdFlagSize))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stdout, "Unable to resize cluster: %s\n", err)
+	}
+
+	result := resizeResult{Name: cmdFlagName}
+	if !cluster.Stopped() {
+		result.Size = cluster.Size()
+	}
+
+	if jsonFlag {
+		b, _ := json.Marshal(result)
+		fmt.Fprintf(os.Stdout, "%s\n", string(b))
+		return
+	}
+	fmt.Fprintf(os.Stdout, "Cluster \"%s\" has %d instances\n", result.Name, result.Size)
+}
+
 func clusterCommand() {
 	if cmdFlagInstance {
 		fmt.Fprint(os.Stdout, "Cannot specify -instance and -cluster at the same time\n")
-	} else if cmdFlagGrow {
-		if cmdFlagShrink {
-			fmt.Fprint(os.Stdout, "Cannot specify -shrink and -grow at the same time\n")
+	} else if cmdFlagPlan {
+		planCluster()
+	} else if cmdFlagStop {
+		if _, ok := am.Cluster(cmdFlagName); !ok {
+			fmt.Fprintf(os.Stdout, "Cluster \"%s\" does not exist\n", cmdFlagName)
+		} else if err := am.StopCluster(cmdFlagName); err != nil {
+			fmt.Fprintf(os.Stdout, "Unable to stop cluster: %s\n", err)
+		} else {
+			fmt.Fprintf(os.Stdout, "Stopped cluster \"%s\"\n", cmdFlagName)
 		}
+	} else if cmdFlagGrow && cmdFlagShrink {
+		fmt.Fprint(os.Stdout, "Cannot specify -shrink and -grow at the same time\n")
+	} else if cmdFlagGrow || cmdFlagShrink {
+		resizeCluster()
 	} else if cmdFlagDumpConfig {
-		configs := make([]*testutil.TestServerConfig, 0)
 		cluster, ok := am.Cluster(cmdFlagName)
 		if ok {
-			// TODO: this is a bad idea....
-			for i := 0; i < cluster.Size(); i++ {
-				inst := cluster.Instance(uint8(i))
-				configs = append(configs, inst.Config())
-			}
-			b, _ := json.Marshal(configs)
-			fmt.Fprintf(os.Stdout, "%s\n", string(b))
+			printClusterConfigs(cluster)
 		} else {
 			fmt.Fprint(os.Stdout, "[]\n")
 		}
+	} else {
+		if cmdFlagSize == 0 || cmdFlagSize > math.MaxUint8 {
+			fmt.Fprintf(os.Stdout, "-size must be between 1 and %d\n", math.MaxUint8)
+			return
+		}
+		cluster, err := am.NewCluster(cmdFlagName, uint8(cmdFlagSize), clusterAgentOutput, instanceDefaults...)
+		if err != nil {
+			fmt.Fprintf(os.Stdout, "Unable to start cluster: %s\n", err)
+			return
+		}
+		printClusterConfigs(cluster)
 	}
 }
 
+// printClusterConfigs prints the config of each live member of cluster as a JSON array
+func printClusterConfigs(cluster *agentman.TestCluster) {
+	configs := make([]json.RawMessage, 0)
+	for i := 0; i < cluster.Size(); i++ {
+		b, err := cluster.Instance(uint8(i)).ConfigJSON()
+		if err != nil {
+			continue
+		}
+		configs = append(configs, b)
+	}
+	b, _ := json.Marshal(configs)
+	fmt.Fprintf(os.Stdout, "%s\n", string(b))
+}
+
+type listCluster struct {
+	Name string `json:"name"`
+	Size int    `json:"size"`
+}
+
+type listResult struct {
+	Instances []string      `json:"instances"`
+	Clusters  []listCluster `json:"clusters"`
+}
+
+// listCommand prints the names of every instance and cluster, along with the size of each cluster
+func listCommand() {
+	result := listResult{
+		Instances: am.InstanceNames(),
+		Clusters:  make([]listCluster, 0),
+	}
+	sort.Strings(result.Instances)
+
+	clusterNames := am.ClusterNames()
+	sort.Strings(clusterNames)
+	for _, name := range clusterNames {
+		if cluster, ok := am.Cluster(name); ok {
+			result.Clusters = append(result.Clusters, listCluster{Name: name, Size: cluster.Size()})
+		}
+	}
+
+	if jsonFlag {
+		b, _ := json.Marshal(result)
+		fmt.Fprintf(os.Stdout, "%s\n", string(b))
+		return
+	}
+
+	for _, name := range result.Instances {
+		fmt.Fprintf(os.Stdout, "instance %s\n", name)
+	}
+	for _, cluster := range result.Clusters {
+		fmt.Fprintf(os.Stdout, "cluster %s (%d instances)\n", cluster.Name, cluster.Size)
+	}
+}
+
+// resetCmdFlags restores every command flag to its default, so flags given to one command do not carry over to the
+// next
+func resetCmdFlags() {
+	cmdFlags.VisitAll(func(f *flag.Flag) {
+		f.Value.Set(f.DefValue)
+	})
+}
+
 func parseNewCmd(input string) {
 	cmdLock.Lock()
 	defer cmdLock.Unlock()
 
+	resetCmdFlags()
+
 	err := cmdFlags.Parse(strings.Split(input, " "))
 	if err != nil {
 		fmt.Fprintf(os.Stdout, "Unable to parse input: %s\n", err)
 		return
 	}
 
+	if cmdFlagList {
+		listCommand()
+		return
+	}
+
 	if cmdFlagName == "" {
 		fmt.Fprint(os.Stdout, "-name must be populated\n")
 		return
@@ -140,15 +396,32 @@ func parseNewCmd(input string) {
 	}
 }
 
+// shutdown stops every instance and cluster before exiting, so no consul processes outlive the daemon
+func shutdown() {
+	if err := am.Stop(); err != nil {
+		logf(false, "Did not shut down cleanly: %s", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
 func main() {
 	flag.BoolVar(&quietFlag, "quiet", false, "Enable quiet mode")
 	flag.BoolVar(&debugFlag, "debug", false, "Enable debug mode")
+	flag.BoolVar(&jsonFlag, "json", false, "Enable JSON output mode")
+	flag.BoolVar(&verboseAgentsFlag, "verbose-agents", false, "Route consul agent output to stdout and stderr")
+	flag.StringVar(&configFlag, "config", "", "JSON file of defaults for new instances, reloaded on SIGHUP")
 	flag.Parse()
 
 	log(false, "Booting up AgentMan daemon...")
 
 	cmdLock = new(sync.Mutex)
 
+	if err := loadConfig(); err != nil {
+		logf(false, "%s", err)
+		os.Exit(1)
+	}
+
 	am = agentman.NewAgentMan()
 
 	cmdFlags = flag.NewFlagSet("command", flag.ContinueOnError)
@@ -160,24 +433,31 @@ func main() {
 	cmdFlags.BoolVar(&cmdFlagShrink, "shrink", false, "Shrink cluster -name by -size")
 	cmdFlags.UintVar(&cmdFlagSize, "size", 0, "Amount to create, grow, or shrink cluster -name by")
 	cmdFlags.BoolVar(&cmdFlagDumpConfig, "dump-config", false, "Dump configuration of instance or cluster -name")
-
-	done := make(chan struct{})
+	cmdFlags.BoolVar(&cmdFlagList, "list", false, "List the names of all instances and clusters along with cluster sizes")
+	cmdFlags.BoolVar(&cmdFlagPlan, "plan", false, "Print the instances cluster -name of -size would consist of without creating it.  Ports are chosen as each instance starts, so cannot be planned")
 
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGINFO)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGINFO, syscall.SIGHUP)
 
 	stdinChan := make(chan string, 10)
 	reader := bufio.NewReader(os.Stdin)
 
+	// stdinChan is closed once stdin is, after every command read has been queued, so piped commands all run before
+	// the daemon shuts down
 	go func() {
-		defer close(done)
+		defer close(stdinChan)
 		for {
 			input, err := reader.ReadString('\n')
-			if err != nil {
+			if input = strings.TrimSpace(input); input != "" {
+				stdinChan <- input
+			}
+			if err == io.EOF {
+				log(false, "Stdin closed")
+				return
+			} else if err != nil {
 				logf(false, "Unable to read from stdin: %s", err)
 				return
 			}
-			stdinChan <- strings.TrimSpace(input)
 		}
 	}()
 
@@ -187,19 +467,21 @@ func main() {
 			switch sig {
 			case syscall.SIGINT, syscall.SIGTERM:
 				logf(false, "Saw signal %s, shutting down...", sig)
-				err := am.Stop()
-				if err != nil {
-					logf(false, "Did not shut down cleanly: %s", err)
-					os.Exit(1)
+				shutdown()
+			case syscall.SIGHUP:
+				if err := loadConfig(); err != nil {
+					logf(false, "Unable to reload config, keeping previous defaults: %s", err)
 				} else {
-					os.Exit(0)
+					logf(false, "Reloaded config from %s", configFlag)
 				}
 			case syscall.SIGINFO:
 				fmt.Fprintf(os.Stdout, "Instances: [\"%s\"]; Clusters: [\"%s\"];", strings.Join(am.InstanceNames(), "\", \""), strings.Join(am.ClusterNames(), "\", \""))
 			}
-		case <-done:
-			os.Exit(0)
-		case cmd := <-stdinChan:
+		case cmd, ok := <-stdinChan:
+			if !ok {
+				log(false, "No more input, shutting down...")
+				shutdown()
+			}
 			parseNewCmd(cmd)
 		}
 	}
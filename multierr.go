@@ -1,6 +1,7 @@
 package agentman
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
@@ -53,6 +54,32 @@ func (e *MultiErr) Error() string {
 	return strings.TrimSpace(errStr)
 }
 
+// FormatErrors joins the contained errors with sep, optionally prefixing each with its index
+func (e *MultiErr) FormatErrors(sep string, withIndex bool) string {
+	e.m.Lock()
+	defer e.m.Unlock()
+	parts := make([]string, len(e.errs))
+	for i, err := range e.errs {
+		if withIndex {
+			parts[i] = fmt.Sprintf("%d - %s", i, err)
+		} else {
+			parts[i] = err.Error()
+		}
+	}
+	return strings.Join(parts, sep)
+}
+
+// MarshalJSON encodes the contained errors as an array of their messages
+func (e *MultiErr) MarshalJSON() ([]byte, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	msgs := make([]string, len(e.errs))
+	for i, err := range e.errs {
+		msgs[i] = err.Error()
+	}
+	return json.Marshal(msgs)
+}
+
 func (e *MultiErr) String() string {
 	return e.Error()
 }
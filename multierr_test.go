@@ -0,0 +1,52 @@
+package agentman_test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/dcarbone/agentman"
+	"testing"
+)
+
+func TestMultiErr(t *testing.T) {
+	me := agentman.NewMultiErr()
+	me.Add(errors.New("first"))
+	me.Add(nil)
+	me.Add(errors.New("second"))
+
+	t.Run("Error", func(t *testing.T) {
+		if me.Error() != "first;\nsecond;" {
+			t.Logf("Unexpected Error() output: %q", me.Error())
+			t.FailNow()
+		}
+	})
+
+	t.Run("FormatErrors", func(t *testing.T) {
+		if out := me.FormatErrors("\n", false); out != "first\nsecond" {
+			t.Logf("Unexpected FormatErrors() output without index: %q", out)
+			t.FailNow()
+		}
+		if out := me.FormatErrors(", ", true); out != "0 - first, 1 - second" {
+			t.Logf("Unexpected FormatErrors() output with index: %q", out)
+			t.FailNow()
+		}
+	})
+
+	t.Run("MarshalJSON", func(t *testing.T) {
+		b, err := json.Marshal(map[string]interface{}{"errors": me})
+		if err != nil {
+			t.Logf("Unable to marshal: %s", err)
+			t.FailNow()
+		}
+		var out struct {
+			Errors []string `json:"errors"`
+		}
+		if err = json.Unmarshal(b, &out); err != nil {
+			t.Logf("Unable to unmarshal %s: %s", string(b), err)
+			t.FailNow()
+		}
+		if len(out.Errors) != 2 || out.Errors[0] != "first" || out.Errors[1] != "second" {
+			t.Logf("Unexpected round-tripped errors: %v", out.Errors)
+			t.FailNow()
+		}
+	})
+}
@@ -0,0 +1,374 @@
+package agentman
+
+import (
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	"time"
+)
+
+// DefaultLeaderTimeout is how long operations that must wait for a leader to be elected will wait
+var DefaultLeaderTimeout = 30 * time.Second
+
+// HasQuorum will attempt to determine whether enough voting servers are alive for the cluster to make progress.  The
+// raft configuration is read from the first reachable instance using a stale query, so it may be answered even when
+// the cluster has no leader.  An error is returned if no instance could be reached.
+func (cl *TestCluster) HasQuorum() (bool, error) {
+	cl.m.Lock()
+	defer cl.m.Unlock()
+	if cl.stopped {
+		return false, fmt.Errorf("cluster \"%s\" is defunct", cl.name)
+	}
+
+	conf, err := cl.raftConfiguration(&api.QueryOptions{AllowStale: true})
+	if err != nil {
+		return false, err
+	}
+
+	live := make(map[string]struct{}, len(cl.instances))
+	for _, instance := range cl.instances {
+		if addr, ok := instance.raftAddr(); ok {
+			live[addr] = struct{}{}
+		}
+	}
+
+	voters, alive := 0, 0
+	for _, server := range conf.Servers {
+		if !server.Voter {
+			continue
+		}
+		voters++
+		if _, ok := live[server.Address]; ok {
+			alive++
+		}
+	}
+
+	return alive >= (voters/2)+1, nil
+}
+
+// RaftConfiguration will attempt to retrieve the raft peer set of the cluster from the first reachable instance
+func (cl *TestCluster) RaftConfiguration() (*api.RaftConfiguration, error) {
+	cl.m.Lock()
+	defer cl.m.Unlock()
+	if cl.stopped {
+		return nil, fmt.Errorf("cluster \"%s\" is defunct", cl.name)
+	}
+	return cl.raftConfiguration(nil)
+}
+
+// RaftRemovePeer will attempt to remove instance num of this cluster from the raft peer set, typically after it has
+// been stopped without leaving.  The removal is performed through the first live instance able to do so.
+func (cl *TestCluster) RaftRemovePeer(num uint8) error {
+	cl.m.Lock()
+	defer cl.m.Unlock()
+	if cl.stopped {
+		return fmt.Errorf("cluster \"%s\" is defunct", cl.name)
+	}
+	if int(num) >= len(cl.instances) {
+		return fmt.Errorf("cluster \"%s\" has no instance %d", cl.name, num)
+	}
+
+	conf, err := cl.raftConfiguration(nil)
+	if err != nil {
+		return err
+	}
+
+	id, addr := cl.instances[num].peerIdentity()
+	for _, server := range conf.Servers {
+		if server.ID == id || server.Address == addr {
+			return cl.raftRemovePeer(server.ID)
+		}
+	}
+
+	return fmt.Errorf("instance \"%s\" is not a raft peer of cluster \"%s\"", cl.instances[num].Name(), cl.name)
+}
+
+// RaftRemoveFailedPeers will attempt to remove every raft peer which is not backed by a live instance of this cluster
+func (cl *TestCluster) RaftRemoveFailedPeers() error {
+	cl.m.Lock()
+	defer cl.m.Unlock()
+	if cl.stopped {
+		return fmt.Errorf("cluster \"%s\" is defunct", cl.name)
+	}
+
+	conf, err := cl.raftConfiguration(nil)
+	if err != nil {
+		return err
+	}
+
+	live := make(map[string]struct{}, len(cl.instances))
+	for _, instance := range cl.instances {
+		if addr, ok := instance.raftAddr(); ok {
+			live[addr] = struct{}{}
+		}
+	}
+
+	errs := NewMultiErr()
+	for _, server := range conf.Servers {
+		if _, ok := live[server.Address]; !ok {
+			errs.Add(cl.raftRemovePeer(server.ID))
+		}
+	}
+
+	if errs.Size() > 0 {
+		return errs
+	}
+	return nil
+}
+
+// raftRemovePeer removes a peer by its raft ID through the first live instance able to do so.  Caller must hold lock.
+func (cl *TestCluster) raftRemovePeer(id string) error {
+	errs := NewMultiErr()
+	for _, instance := range cl.instances {
+		client, ok := instance.apiClient()
+		if !ok {
+			continue
+		}
+		err := client.Operator().RaftRemovePeerByID(id, nil)
+		if err == nil {
+			return nil
+		}
+		errs.Add(fmt.Errorf("instance \"%s\": %s", instance.Name(), err))
+	}
+
+	if errs.Size() == 0 {
+		return fmt.Errorf("cluster \"%s\" has no live instances", cl.name)
+	}
+	return fmt.Errorf("unable to remove raft peer \"%s\" from cluster \"%s\": %s", id, cl.name, errs)
+}
+
+// raftConfiguration returns the raft configuration as seen by the first live instance able to answer.  Caller must
+// hold lock.
+func (cl *TestCluster) raftConfiguration(q *api.QueryOptions) (*api.RaftConfiguration, error) {
+	errs := NewMultiErr()
+	for _, instance := range cl.instances {
+		client, ok := instance.apiClient()
+		if !ok {
+			continue
+		}
+		conf, err := client.Operator().RaftGetConfiguration(q)
+		if err == nil {
+			return conf, nil
+		}
+		errs.Add(fmt.Errorf("instance \"%s\": %s", instance.Name(), err))
+	}
+
+	if errs.Size() == 0 {
+		return nil, fmt.Errorf("cluster \"%s\" has no live instances", cl.name)
+	}
+	return nil, fmt.Errorf("no instance in cluster \"%s\" could be reached: %s", cl.name, errs)
+}
+
+// GrowAndWait will attempt to grow the cluster by n instances as Grow does, then block until each new instance is a
+// voter in the raft configuration or the timeout elapses.  Should any not be promoted in time, a *VoterTimeoutError
+// naming them is returned.
+func (cl *TestCluster) GrowAndWait(n uint8, cb ClusterServerConfigCallback, timeout time.Duration) error {
+	cl.m.Lock()
+	if cl.stopped {
+		cl.unlock()
+		return fmt.Errorf("cluster \"%s\" is defunct", cl.name)
+	}
+
+	current := len(cl.instances)
+	err := cl.grow(n, cb, cl.opts, 0, int(n))
+	added := append(make([]*TestInstance, 0, len(cl.instances)-current), cl.instances[current:]...)
+	cl.unlock()
+	if err != nil {
+		return err
+	}
+
+	// the lock is only held for each poll, leaving the cluster usable while waiting
+	deadline := time.Now().Add(timeout)
+	for {
+		cl.m.Lock()
+		if cl.stopped {
+			cl.m.Unlock()
+			return fmt.Errorf("cluster \"%s\" is defunct", cl.name)
+		}
+		conf, err := cl.raftConfiguration(nil)
+		cl.m.Unlock()
+
+		pending := make([]string, 0)
+		if err != nil {
+			for _, instance := range added {
+				pending = append(pending, instance.Name())
+			}
+		} else {
+			voters := make(map[string]struct{}, len(conf.Servers))
+			for _, server := range conf.Servers {
+				if server.Voter {
+					voters[server.Address] = struct{}{}
+				}
+			}
+			for _, instance := range added {
+				addr, _ := instance.raftAddr()
+				if _, ok := voters[addr]; !ok {
+					pending = append(pending, instance.Name())
+				}
+			}
+		}
+
+		if len(pending) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return &VoterTimeoutError{Name: cl.name, Timeout: timeout, Pending: pending}
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// RaftStats will attempt to retrieve the raft stats each live instance reports about itself, such as its
+// applied_index, commit_index, and state, keyed by instance name.  Should any instance fail to answer, the stats of
+// those which did are returned alongside a *MultiErr describing the failures.
+func (cl *TestCluster) RaftStats() (map[string]map[string]string, error) {
+	cl.m.Lock()
+	defer cl.m.Unlock()
+	if cl.stopped {
+		return nil, fmt.Errorf("cluster \"%s\" is defunct", cl.name)
+	}
+
+	stats := make(map[string]map[string]string, len(cl.instances))
+	errs := NewMultiErr()
+	for _, instance := range cl.liveInstances() {
+		client, ok := instance.apiClient()
+		if !ok {
+			continue
+		}
+		self, err := client.Agent().Self()
+		if err != nil {
+			errs.Add(fmt.Errorf("instance \"%s\": %s", instance.Name(), err))
+			continue
+		}
+		raw, ok := self["Stats"]["raft"].(map[string]interface{})
+		if !ok {
+			errs.Add(fmt.Errorf("instance \"%s\" reported no raft stats", instance.Name()))
+			continue
+		}
+		raft := make(map[string]string, len(raw))
+		for k, v := range raw {
+			raft[k] = fmt.Sprint(v)
+		}
+		stats[instance.Name()] = raft
+	}
+
+	if errs.Size() > 0 {
+		return stats, errs
+	}
+	return stats, nil
+}
+
+// SetAutopilotConfig will attempt to update the autopilot configuration of the cluster via the current leader
+func (cl *TestCluster) SetAutopilotConfig(conf *api.AutopilotConfiguration) error {
+	cl.m.Lock()
+	defer cl.m.Unlock()
+	if cl.stopped {
+		return fmt.Errorf("cluster \"%s\" is defunct", cl.name)
+	}
+
+	client, err := cl.leaderClient()
+	if err != nil {
+		return err
+	}
+
+	return client.Operator().AutopilotSetConfiguration(conf, nil)
+}
+
+// AutopilotHealth will attempt to retrieve the autopilot server health report from the current leader
+func (cl *TestCluster) AutopilotHealth() (*api.OperatorHealthReply, error) {
+	cl.m.Lock()
+	defer cl.m.Unlock()
+	if cl.stopped {
+		return nil, fmt.Errorf("cluster \"%s\" is defunct", cl.name)
+	}
+
+	client, err := cl.leaderClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Operator().AutopilotServerHealth(nil)
+}
+
+// leader returns the live instance currently holding raft leadership.  Caller must hold lock.
+func (cl *TestCluster) leader() (*TestInstance, error) {
+	var addr string
+
+	errs := NewMultiErr()
+	for _, instance := range cl.instances {
+		client, ok := instance.apiClient()
+		if !ok {
+			continue
+		}
+		leader, err := client.Status().Leader()
+		if err != nil {
+			errs.Add(fmt.Errorf("instance \"%s\": %s", instance.Name(), err))
+			continue
+		}
+		addr = leader
+		break
+	}
+
+	if addr == "" {
+		if errs.Size() > 0 {
+			return nil, fmt.Errorf("unable to determine leader of cluster \"%s\": %s", cl.name, errs)
+		}
+		return nil, fmt.Errorf("cluster \"%s\" has no leader", cl.name)
+	}
+
+	for _, instance := range cl.instances {
+		if raftAddr, ok := instance.raftAddr(); ok && raftAddr == addr {
+			return instance, nil
+		}
+	}
+
+	return nil, fmt.Errorf("leader \"%s\" of cluster \"%s\" is not a live member", addr, cl.name)
+}
+
+// WaitForLeader will block until the cluster has elected a leader or the timeout elapses
+func (cl *TestCluster) WaitForLeader(timeout time.Duration) error {
+	cl.m.Lock()
+	defer cl.m.Unlock()
+	if cl.stopped {
+		return fmt.Errorf("cluster \"%s\" is defunct", cl.name)
+	}
+	return cl.waitForLeader(timeout)
+}
+
+// waitForLeader polls for a leader until one is found or the timeout elapses.  Caller must hold lock.
+func (cl *TestCluster) waitForLeader(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		_, err := cl.leader()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("cluster \"%s\" did not elect a leader within %s: %s", cl.name, timeout, err)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// LeaderClient will attempt to return the api client of the instance currently holding raft leadership, returning an
+// error should there be no leader
+func (cl *TestCluster) LeaderClient() (*api.Client, error) {
+	cl.m.Lock()
+	defer cl.m.Unlock()
+	if cl.stopped {
+		return nil, fmt.Errorf("cluster \"%s\" is defunct", cl.name)
+	}
+	return cl.leaderClient()
+}
+
+// leaderClient returns the api client of the current leader.  Caller must hold lock.
+func (cl *TestCluster) leaderClient() (*api.Client, error) {
+	leader, err := cl.leader()
+	if err != nil {
+		return nil, err
+	}
+	client, ok := leader.apiClient()
+	if !ok {
+		return nil, fmt.Errorf("leader \"%s\" of cluster \"%s\" is defunct", leader.Name(), cl.name)
+	}
+	return client, nil
+}
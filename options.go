@@ -0,0 +1,395 @@
+package agentman
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/testutil"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// LogLevels are the log levels accepted by consul
+var LogLevels = []string{"TRACE", "DEBUG", "INFO", "WARN", "ERR"}
+
+// reservedArgs are consul flags set by agentman or testutil which may not be provided via WithArgs
+var reservedArgs = []string{"data-dir", "dev", "config-format"}
+
+// uuidPattern matches the hyphenated hex form consul requires of node ids
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// Option configures optional behavior of test instances and clusters.  Options which only apply to clusters are
+// ignored when creating a single instance.
+type Option func(*options)
+
+type options struct {
+	progress    func(done, total int)
+	extraConfig string
+	nodeMeta    func(num uint8) map[string]string
+	logLevel    string
+	wanJoin     bool
+	postStart   func(*TestInstance) error
+	apiConfig   func(*api.Config)
+
+	checkpoint         bool
+	anonymousSignature bool
+
+	retainDataOnFailure bool
+
+	args []string
+
+	bootstrapTimeout time.Duration
+
+	ports *testutil.TestPortConfig
+
+	telemetry *TelemetryConfig
+	gossip    *GossipTiming
+
+	nodeID  string
+	nodeIDs func(num uint8) string
+
+	noBootstrap bool
+
+	// concurrency returns the cap on how many cluster members are stopped at once
+	concurrency func() int
+
+	// startGate is called before each instance starts, set by managers pacing startups
+	startGate func()
+}
+
+func buildOptions(opts []Option) *options {
+	o := new(options)
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// validate checks the options for values consul would reject
+func (o *options) validate() error {
+	if o.extraConfig != "" && !json.Valid([]byte(o.extraConfig)) {
+		return errors.New("extra config is not valid JSON")
+	}
+	if o.logLevel != "" {
+		valid := false
+		for _, level := range LogLevels {
+			if strings.EqualFold(o.logLevel, level) {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return fmt.Errorf("log level \"%s\" is not one of [\"%s\"]", o.logLevel, strings.Join(LogLevels, "\", \""))
+		}
+	}
+	if o.ports != nil {
+		seen := make(map[int]string)
+		for name, port := range o.portMap() {
+			if port < 0 || port > 65535 {
+				return fmt.Errorf("%s port %d is out of range", name, port)
+			}
+			if port == 0 {
+				continue
+			}
+			if other, ok := seen[port]; ok {
+				return fmt.Errorf("%s and %s ports are both set to %d", other, name, port)
+			}
+			seen[port] = name
+		}
+	}
+	if o.nodeID != "" && !uuidPattern.MatchString(o.nodeID) {
+		return fmt.Errorf("node id \"%s\" is not a valid UUID", o.nodeID)
+	}
+	if o.gossip != nil {
+		if err := o.gossip.validate(); err != nil {
+			return fmt.Errorf("gossip timing %s", err)
+		}
+	}
+	for _, arg := range o.args {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		name := strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0]
+		for _, reserved := range reservedArgs {
+			if name == reserved {
+				return fmt.Errorf("arg \"%s\" conflicts with one set by agentman", arg)
+			}
+		}
+	}
+	return nil
+}
+
+// validateCluster checks the options for those which may only be applied to a single instance, as every cluster
+// member would otherwise be given the same value
+func (o *options) validateCluster() error {
+	if o.ports != nil {
+		return errors.New("ports may only be assigned to a single instance, members would all bind the same ones")
+	}
+	if o.nodeID != "" {
+		return errors.New("a node id may only be assigned to a single instance, use WithNodeIDs to give each member its own")
+	}
+	return nil
+}
+
+// WithProgress registers a callback invoked as each cluster member comes up and joins, with the number of members
+// finished so far out of the total being added by the current operation
+func WithProgress(fn func(done, total int)) Option {
+	return func(o *options) {
+		o.progress = fn
+	}
+}
+
+// WithExtraConfig supplies a raw JSON config fragment which consul merges over the generated config, for keys the
+// testutil config struct does not expose
+func WithExtraConfig(raw string) Option {
+	return func(o *options) {
+		o.extraConfig = raw
+	}
+}
+
+// WithNodeMeta assigns node metadata to each cluster member, as returned by fn for that member's number.  Metadata is
+// merged over any set by the config callback.
+func WithNodeMeta(fn func(num uint8) map[string]string) Option {
+	return func(o *options) {
+		o.nodeMeta = fn
+	}
+}
+
+// WithLogLevel sets the log level of spawned agents, overriding any set by the config callback.  level must be one of
+// LogLevels.
+func WithLogLevel(level string) Option {
+	return func(o *options) {
+		o.logLevel = level
+	}
+}
+
+// WithWANJoin has new cluster members join over the WAN pool rather than the LAN pool, for clusters whose members are
+// spread across simulated datacenters.  Members join via their WANAddr.
+func WithWANJoin() Option {
+	return func(o *options) {
+		o.wanJoin = true
+	}
+}
+
+// WithPostStart registers fn to be run against each instance once its server and client are ready, and before it
+// joins any cluster.  Should fn return an error the instance is stopped and creation fails with an
+// *InstanceStartError in PhasePostStart.
+func WithPostStart(fn func(*TestInstance) error) Option {
+	return func(o *options) {
+		o.postStart = fn
+	}
+}
+
+// WithAPIConfig registers fn to adjust the config each instance's api client is created with, allowing a custom http
+// client, token, TLS config, wait time, and so on.  The address is always reset to point at the instance afterwards.
+func WithAPIConfig(fn func(*api.Config)) Option {
+	return func(o *options) {
+		o.apiConfig = fn
+	}
+}
+
+// WithCheckpoint re-enables consul's update checks against checkpoint, which are otherwise disabled to avoid outbound
+// calls during tests.  The anonymous signature which identifies the agent to checkpoint is only sent if
+// anonymousSignature is true.
+func WithCheckpoint(anonymousSignature bool) Option {
+	return func(o *options) {
+		o.checkpoint = true
+		o.anonymousSignature = anonymousSignature
+	}
+}
+
+// WithRetainDataOnFailure leaves an instance's files, including its data dir, in place should it fail to start or
+// stop, or should the test using NewTestInstanceT fail, logging their location for post-mortem.  Files are still
+// removed after a successful stop.
+func WithRetainDataOnFailure() Option {
+	return func(o *options) {
+		o.retainDataOnFailure = true
+	}
+}
+
+// WithArgs appends extra command line arguments to the consul agent invocation, for behaviors the config struct does
+// not expose.  Arguments are passed after those generated from the config, so later flags override earlier ones where
+// consul allows.  Flags agentman relies on, such as -data-dir, are rejected.
+func WithArgs(args ...string) Option {
+	return func(o *options) {
+		o.args = append(o.args, args...)
+	}
+}
+
+// WithBootstrapTimeout sets how long a new cluster waits for a leader to be elected once all of its instances are up,
+// before it is torn down.  Defaults to DefaultLeaderTimeout.
+func WithBootstrapTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.bootstrapTimeout = d
+	}
+}
+
+// WithPorts assigns explicit ports to a single instance, for when another process must connect to a known port.  Ports
+// left at 0 are chosen at random as usual.  Should an explicit port already be in use, creation fails with an error
+// wrapping ErrPortInUse.  Clusters reject this option, members' ports may instead be set by the config callback.
+func WithPorts(ports *testutil.TestPortConfig) Option {
+	return func(o *options) {
+		o.ports = ports
+	}
+}
+
+// WithNodeID assigns a fixed node id to a single instance in place of the random one consul would generate, so
+// assertions referencing it are reproducible.  id must be a UUID.  Clusters reject this option in favor of WithNodeIDs.
+func WithNodeID(id string) Option {
+	return func(o *options) {
+		o.nodeID = id
+	}
+}
+
+// WithNodeIDs assigns each cluster member the node id returned by fn for that member's number, as WithNodeID does for
+// a single instance.  Each id must be a UUID, and creation of a member fails otherwise.
+func WithNodeIDs(fn func(num uint8) string) Option {
+	return func(o *options) {
+		o.nodeIDs = fn
+	}
+}
+
+// WithNoBootstrap stops every cluster member from bootstrapping, overriding the config callback, so that the cluster
+// is left without a leader until bootstrapped externally, such as by a tool under test.  Creation does not wait for a
+// leader, and WaitForLeader, RestartAll, and anything else requiring one will time out until then.
+func WithNoBootstrap() Option {
+	return func(o *options) {
+		o.noBootstrap = true
+	}
+}
+
+// WithMaxConcurrency caps how many instances a cluster stops at once when shrinking.  Values less than 1 use
+// GOMAXPROCS, which is also the default, and 1 stops them one at a time.
+func WithMaxConcurrency(n int) Option {
+	return func(o *options) {
+		o.concurrency = func() int {
+			if n < 1 {
+				return runtime.GOMAXPROCS(0)
+			}
+			return n
+		}
+	}
+}
+
+// withConcurrency has the cluster's max concurrency read from fn, set by managers so that their limit applies
+func withConcurrency(fn func() int) Option {
+	return func(o *options) {
+		o.concurrency = fn
+	}
+}
+
+// withStartGate has fn called before each instance starts, blocking its startup until fn returns
+func withStartGate(fn func()) Option {
+	return func(o *options) {
+		o.startGate = fn
+	}
+}
+
+// TelemetryConfig describes where consul sends its metrics, mirroring the keys of the agent's telemetry config block
+// which the testutil config struct does not expose.  Fields left at their zero value are omitted.
+type TelemetryConfig struct {
+	// StatsdAddr is the host:port of a statsd server metrics are sent to over UDP
+	StatsdAddr string `json:"statsd_address,omitempty"`
+	// StatsiteAddr is the host:port of a statsite server metrics are streamed to over TCP
+	StatsiteAddr string `json:"statsite_address,omitempty"`
+	// PrometheusRetentionTime enables the prometheus format of the metrics endpoint, retaining metrics for this long
+	PrometheusRetentionTime time.Duration `json:"-"`
+	// DisableHostname stops metric names being prefixed with the node name
+	DisableHostname bool `json:"disable_hostname,omitempty"`
+	// MetricsPrefix replaces the default "consul" prefix of metric names
+	MetricsPrefix string `json:"metrics_prefix,omitempty"`
+}
+
+// MarshalJSON renders the config as the value of consul's telemetry config block
+func (t TelemetryConfig) MarshalJSON() ([]byte, error) {
+	type plain TelemetryConfig
+	out := struct {
+		plain
+		PrometheusRetentionTime string `json:"prometheus_retention_time,omitempty"`
+	}{plain: plain(t)}
+	if t.PrometheusRetentionTime > 0 {
+		out.PrometheusRetentionTime = t.PrometheusRetentionTime.String()
+	}
+	return json.Marshal(out)
+}
+
+// WithTelemetry routes the agent's metrics to the sinks described by telemetry, such as a statsd listener run by the
+// test.  It is applied via a config file alongside any provided by WithExtraConfig, which wins should both set
+// telemetry.
+func WithTelemetry(telemetry *TelemetryConfig) Option {
+	return func(o *options) {
+		o.telemetry = telemetry
+	}
+}
+
+// GossipTiming tunes the LAN gossip protocol, chiefly so failed nodes are detected faster than consul's defaults allow.
+// Fields left at their zero value keep consul's default.
+type GossipTiming struct {
+	// ProbeInterval is how often each node probes a random peer for liveness
+	ProbeInterval time.Duration
+	// ProbeTimeout is how long to wait for a probe to be acknowledged, and must be shorter than ProbeInterval
+	ProbeTimeout time.Duration
+	// SuspicionMult scales how long a suspect node has to refute the suspicion before it is declared failed
+	SuspicionMult int
+	// GossipInterval is how often gossip messages are sent to peers
+	GossipInterval time.Duration
+}
+
+// validate checks the timings are within the ranges memberlist accepts
+func (g *GossipTiming) validate() error {
+	if g.ProbeInterval < 0 || g.ProbeTimeout < 0 || g.GossipInterval < 0 {
+		return errors.New("durations must not be negative")
+	}
+	if g.ProbeInterval > 0 && g.ProbeInterval < 10*time.Millisecond {
+		return fmt.Errorf("probe interval %s is below the minimum of 10ms", g.ProbeInterval)
+	}
+	if g.ProbeInterval > 0 && g.ProbeTimeout >= g.ProbeInterval {
+		return fmt.Errorf("probe timeout %s must be shorter than probe interval %s", g.ProbeTimeout, g.ProbeInterval)
+	}
+	if g.SuspicionMult < 0 || g.SuspicionMult > 10 {
+		return fmt.Errorf("suspicion multiplier %d must be between 1 and 10, or 0 for the default", g.SuspicionMult)
+	}
+	return nil
+}
+
+// config renders the timings as the value of consul's gossip_lan config block
+func (g *GossipTiming) config() map[string]interface{} {
+	conf := make(map[string]interface{})
+	if g.ProbeInterval > 0 {
+		conf["probe_interval"] = g.ProbeInterval.String()
+	}
+	if g.ProbeTimeout > 0 {
+		conf["probe_timeout"] = g.ProbeTimeout.String()
+	}
+	if g.SuspicionMult > 0 {
+		conf["suspicion_mult"] = g.SuspicionMult
+	}
+	if g.GossipInterval > 0 {
+		conf["gossip_interval"] = g.GossipInterval.String()
+	}
+	return conf
+}
+
+// WithGossipTiming applies timing to the LAN gossip of each instance, for chaos tests wanting failures noticed quickly.
+// Aggressive timings on a loaded host risk healthy nodes being declared failed.  Like WithTelemetry it is applied via
+// a config file, which WithExtraConfig wins over.
+func WithGossipTiming(timing *GossipTiming) Option {
+	return func(o *options) {
+		o.gossip = timing
+	}
+}
+
+// portMap returns the explicit ports keyed by name
+func (o *options) portMap() map[string]int {
+	return map[string]int{
+		"dns":      o.ports.DNS,
+		"http":     o.ports.HTTP,
+		"https":    o.ports.HTTPS,
+		"serf_lan": o.ports.SerfLan,
+		"serf_wan": o.ports.SerfWan,
+		"server":   o.ports.Server,
+	}
+}
@@ -0,0 +1,193 @@
+package agentman
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// StateFile records the data dir of every consul process started by agentman, across all processes using it, so that
+// agents left running after a crash may later be found.  Each line holds the pid of the process which started the
+// agent followed by a space and its data dir.  Entries are removed as their servers are stopped, and the file is
+// locked while being read or updated.
+var StateFile = filepath.Join(os.TempDir(), "agentman.state")
+
+// stateMu serializes access to StateFile within this process, flock serializing it across processes
+var stateMu sync.Mutex
+
+// stateEntry is a single line of StateFile
+type stateEntry struct {
+	owner   int
+	dataDir string
+}
+
+// trackDataDir records a newly started server.  Failure to update the state file is not fatal to the server, it only
+// means the process could not be found as an orphan, so is logged rather than returned.
+func trackDataDir(dataDir string) {
+	err := updateState(func(entries []stateEntry) []stateEntry {
+		return append(entries, stateEntry{owner: os.Getpid(), dataDir: dataDir})
+	})
+	if err != nil {
+		log.Printf("agentman: unable to record data dir %s, it will not be found as an orphan: %s", dataDir, err)
+	}
+}
+
+// untrackDataDir removes a stopped server from the state file, logging any failure to do so as trackDataDir does
+func untrackDataDir(dataDir string) {
+	err := updateState(func(entries []stateEntry) []stateEntry {
+		return removeStateEntries(entries, map[string]struct{}{dataDir: {}})
+	})
+	if err != nil {
+		log.Printf("agentman: unable to remove data dir %s from the state file: %s", dataDir, err)
+	}
+}
+
+// updateState locks StateFile and replaces its entries with those returned by fn
+func updateState(fn func(entries []stateEntry) []stateEntry) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	f, err := os.OpenFile(StateFile, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to open state file \"%s\": %s", StateFile, err)
+	}
+	defer f.Close()
+
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("unable to lock state file \"%s\": %s", StateFile, err)
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+
+	entries, err := readStateEntries(f)
+	if err != nil {
+		return err
+	}
+
+	b := new(strings.Builder)
+	for _, entry := range fn(entries) {
+		fmt.Fprintf(b, "%d %s\n", entry.owner, entry.dataDir)
+	}
+
+	if err = f.Truncate(0); err == nil {
+		_, err = f.WriteAt([]byte(b.String()), 0)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write state file \"%s\": %s", StateFile, err)
+	}
+	return nil
+}
+
+// readStateEntries parses every entry of the open state file.  Lines without an owner are kept with an owner of 0.
+func readStateEntries(r io.Reader) ([]stateEntry, error) {
+	entries := make([]stateEntry, 0)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		entry := stateEntry{dataDir: line}
+		if parts := strings.SplitN(line, " ", 2); len(parts) == 2 {
+			if owner, err := strconv.Atoi(parts[0]); err == nil {
+				entry = stateEntry{owner: owner, dataDir: parts[1]}
+			}
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read state file \"%s\": %s", StateFile, err)
+	}
+	return entries, nil
+}
+
+// removeStateEntries returns entries without those of the provided data dirs
+func removeStateEntries(entries []stateEntry, remove map[string]struct{}) []stateEntry {
+	kept := make([]stateEntry, 0, len(entries))
+	for _, entry := range entries {
+		if _, ok := remove[entry.dataDir]; !ok {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// processAlive reports whether a process with pid exists
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// orphans returns the recorded data dirs whose owning process has exited, with the pid of the consul process still
+// running against each, or 0 if there is none.  Entries owned by live processes, including this one, are never
+// reported, so agents of concurrently running processes are left alone.
+func orphans(entries []stateEntry) map[string]int {
+	found := make(map[string]int)
+	for _, entry := range entries {
+		if processAlive(entry.owner) {
+			continue
+		}
+		pid, err := findPID(entry.dataDir)
+		if err != nil {
+			pid = 0
+		}
+		found[entry.dataDir] = pid
+	}
+	return found
+}
+
+// FindOrphanedAgents returns the pids of consul processes started by agentman, as recorded in StateFile, which are
+// still running although the process which started them has exited.  These are typically left behind when a process
+// using agentman exits without stopping its instances.
+func FindOrphanedAgents() ([]int, error) {
+	var found map[string]int
+	err := updateState(func(entries []stateEntry) []stateEntry {
+		found = orphans(entries)
+		return entries
+	})
+	if err != nil {
+		return nil, err
+	}
+	pids := make([]int, 0, len(found))
+	for _, pid := range found {
+		if pid != 0 {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// KillOrphans will kill every process returned by FindOrphanedAgents, removing their entries from StateFile along with
+// those of any orphans no longer running.  Data dirs of killed orphans are left in place.
+func KillOrphans() error {
+	errs := NewMultiErr()
+	err := updateState(func(entries []stateEntry) []stateEntry {
+		found := orphans(entries)
+		remove := make(map[string]struct{}, len(found))
+		for dataDir, pid := range found {
+			if pid != 0 {
+				if err := killPID(pid); err != nil {
+					errs.Add(fmt.Errorf("unable to kill orphan %d with data dir \"%s\": %s", pid, dataDir, err))
+					continue
+				}
+			}
+			remove[dataDir] = struct{}{}
+		}
+		return removeStateEntries(entries, remove)
+	})
+	errs.Add(err)
+
+	if errs.Size() > 0 {
+		return errs
+	}
+	return nil
+}
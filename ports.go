@@ -0,0 +1,143 @@
+package agentman
+
+import (
+	"fmt"
+	"github.com/hashicorp/consul/testutil"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// portFree reports whether port may be bound on host over tcp, and over udp too when udp is true
+func portFree(host string, port int, udp bool) bool {
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return false
+	}
+	l.Close()
+	if udp {
+		pc, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return false
+		}
+		pc.Close()
+	}
+	return true
+}
+
+// freePort asks the os for a port that is currently free on host over both tcp and udp
+func freePort(host string) (int, error) {
+	for attempt := 0; attempt < 10; attempt++ {
+		l, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+		if err != nil {
+			return 0, err
+		}
+		port := l.Addr().(*net.TCPAddr).Port
+		l.Close()
+		if portFree(host, port, true) {
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("unable to find a free port on %s", host)
+}
+
+// claimPorts checks each port in conf is free before consul attempts to bind it.  Ports which are in use but were
+// chosen by testutil, and so are unchanged from picked, are swapped for fresh ones.  Should a port set by the config
+// callback be in use, or no replacement be found, an error wrapping ErrPortInUse is returned.
+func claimPorts(conf *testutil.TestServerConfig, picked testutil.TestPortConfig) error {
+	if conf.Ports == nil {
+		return nil
+	}
+
+	host := conf.Bind
+	if host == "" {
+		host = "127.0.0.1"
+	}
+
+	ports := []struct {
+		name   string
+		port   *int
+		picked int
+		udp    bool
+	}{
+		{"dns", &conf.Ports.DNS, picked.DNS, true},
+		{"http", &conf.Ports.HTTP, picked.HTTP, false},
+		{"https", &conf.Ports.HTTPS, picked.HTTPS, false},
+		{"serf_lan", &conf.Ports.SerfLan, picked.SerfLan, true},
+		{"serf_wan", &conf.Ports.SerfWan, picked.SerfWan, true},
+		{"server", &conf.Ports.Server, picked.Server, false},
+	}
+
+	for _, p := range ports {
+		if *p.port <= 0 || portFree(host, *p.port, p.udp) {
+			continue
+		}
+		if *p.port != p.picked {
+			return fmt.Errorf("%s port %d on %s: %w", p.name, *p.port, host, ErrPortInUse)
+		}
+		port, err := freePort(host)
+		if err != nil {
+			return fmt.Errorf("%s port %d on %s, and no replacement could be found: %s: %w", p.name, *p.port, host, err, ErrPortInUse)
+		}
+		*p.port = port
+	}
+
+	return nil
+}
+
+// applyPorts copies the non-zero ports of explicit into ports
+func applyPorts(ports, explicit *testutil.TestPortConfig) {
+	for _, p := range []struct{ dst, src *int }{
+		{&ports.DNS, &explicit.DNS},
+		{&ports.HTTP, &explicit.HTTP},
+		{&ports.HTTPS, &explicit.HTTPS},
+		{&ports.SerfLan, &explicit.SerfLan},
+		{&ports.SerfWan, &explicit.SerfWan},
+		{&ports.Server, &explicit.Server},
+	} {
+		if *p.src != 0 {
+			*p.dst = *p.src
+		}
+	}
+}
+
+// loopback is the address testutil expects the HTTP API to be reachable on while the agent starts
+const loopback = "127.0.0.1"
+
+// listenHTTP points the HTTP(S) API at the hosts in conf.Addresses.HTTP, or at conf.Bind when that is left at
+// testutil's default.  testutil polls the agent over loopback until it is ready, so loopback is always listened on as
+// well, after the configured hosts.
+func listenHTTP(conf *testutil.TestServerConfig) {
+	var hosts []string
+	if conf.Addresses != nil {
+		hosts = strings.Fields(conf.Addresses.HTTP)
+	}
+	if (len(hosts) == 0 || (len(hosts) == 1 && hosts[0] == loopback)) && conf.Bind != "" {
+		hosts = []string{conf.Bind}
+	}
+	hasLoopback := false
+	for _, host := range hosts {
+		hasLoopback = hasLoopback || host == loopback
+	}
+	if !hasLoopback {
+		hosts = append(hosts, loopback)
+	}
+
+	if conf.Addresses == nil {
+		conf.Addresses = new(testutil.TestAddressConfig)
+	}
+	conf.Addresses.HTTP = strings.Join(hosts, " ")
+	conf.Args = append(conf.Args, "-hcl", fmt.Sprintf("addresses { https = %q }", conf.Addresses.HTTP))
+}
+
+// httpHostPort returns the host:port the HTTP(S) API is reached on, the first host it listens on
+func httpHostPort(conf *testutil.TestServerConfig, port int) string {
+	host := loopback
+	if conf.Addresses != nil {
+		if hosts := strings.Fields(conf.Addresses.HTTP); len(hosts) > 0 {
+			host = hosts[0]
+		}
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
@@ -0,0 +1,77 @@
+package agentman
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// errProcessNotFound is returned by findPID when no process is running with the data dir
+var errProcessNotFound = errors.New("no consul process found")
+
+// findPID locates the consul process started with the provided data dir.  Every instance passes its data dir on the
+// command line, as the testutil package does not expose the process it starts.
+func findPID(dataDir string) (int, error) {
+	pid, _, err := findProcess(dataDir)
+	return pid, err
+}
+
+// findProcess locates the consul process started with the provided data dir, returning its pid and the arguments it
+// was started with.  Arguments are left as a single string, as splitting them on whitespace would break apart paths
+// containing it.
+func findProcess(dataDir string) (int, string, error) {
+	out, err := exec.Command("ps", "-e", "-o", "pid=", "-o", "args=").Output()
+	if err != nil {
+		return 0, "", fmt.Errorf("unable to list processes: %s", err)
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		sep := strings.IndexByte(line, ' ')
+		if sep < 0 {
+			continue
+		}
+		args := strings.TrimSpace(line[sep+1:])
+		if !hasArgPair(args, "-data-dir", dataDir) {
+			continue
+		}
+		pid, err := strconv.Atoi(line[:sep])
+		return pid, args, err
+	}
+
+	return 0, "", fmt.Errorf("data dir \"%s\": %w", dataDir, errProcessNotFound)
+}
+
+// argValue returns the value following the first occurrence of name within args.  The value runs up to the next flag,
+// so may itself contain whitespace.
+func argValue(args, name string) (string, bool) {
+	padded := " " + args + " "
+	i := strings.Index(padded, " "+name+" ")
+	if i < 0 {
+		return "", false
+	}
+	value := padded[i+len(name)+2:]
+	if next := strings.Index(value, " -"); next >= 0 {
+		value = value[:next]
+	}
+	value = strings.TrimSpace(value)
+	return value, value != ""
+}
+
+// hasArgPair reports whether the first occurrence of name within args is followed by value
+func hasArgPair(args, name, value string) bool {
+	v, ok := argValue(args, name)
+	return ok && v == value
+}
+
+// killPID sends SIGKILL to the process
+func killPID(pid int) error {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return proc.Kill()
+}
@@ -0,0 +1,113 @@
+package agentman
+
+import (
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	"net/http"
+	"sync/atomic"
+)
+
+// RoundRobinClient will return an api client whose requests are spread across the live instances of this cluster,
+// rotating to the next instance with each request.  Instances that have been stopped are skipped.  The client is
+// configured as those of the members are, including TLS settings and any WithAPIConfig adjustments.
+//
+// Requests made with default consistency are forwarded by whichever server receives them to the leader, so spreading
+// them only changes which server does the forwarding.  Requests made with AllowStale set will be answered from the
+// local state of the receiving server, which may lag behind the leader, so consecutive stale reads may observe
+// different, and even older, versions of the data.
+func (cl *TestCluster) RoundRobinClient() (*api.Client, error) {
+	cl.m.Lock()
+	defer cl.m.Unlock()
+	if cl.stopped {
+		return nil, fmt.Errorf("cluster \"%s\" is defunct", cl.name)
+	}
+
+	addrs := cl.liveAddrs()
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("cluster \"%s\" has no live instances", cl.name)
+	}
+
+	// members share their api config, so that of any live one supplies the TLS settings and token for all
+	var apiConf *api.Config
+	for _, instance := range cl.instances {
+		if conf, ok := instance.clientConfig(); ok {
+			apiConf = conf
+			break
+		}
+	}
+	if apiConf == nil {
+		return nil, fmt.Errorf("cluster \"%s\" has no live instances", cl.name)
+	}
+
+	base, err := clientTransport(apiConf)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create transport for cluster \"%s\": %s", cl.name, err)
+	}
+
+	rr := &roundRobinTransport{
+		cl:   cl,
+		base: base,
+	}
+
+	apiConf.Scheme = addrs[0].scheme
+	apiConf.Address = addrs[0].hostport
+	apiConf.HttpClient = &http.Client{Transport: rr}
+
+	return api.NewClient(apiConf)
+}
+
+type (
+	instanceAddr struct {
+		scheme   string
+		hostport string
+	}
+
+	// roundRobinTransport rewrites the target of each request to the next live instance in the cluster
+	roundRobinTransport struct {
+		cl   *TestCluster
+		next uint32
+		base http.RoundTripper
+	}
+)
+
+// liveAddrs returns the api address of every live instance in the cluster.  Caller must hold lock.
+func (cl *TestCluster) liveAddrs() []instanceAddr {
+	addrs := make([]instanceAddr, 0, len(cl.instances))
+	for _, instance := range cl.instances {
+		if scheme, hostport, err := instance.Addr(); err == nil {
+			addrs = append(addrs, instanceAddr{scheme: scheme, hostport: hostport})
+		}
+	}
+	return addrs
+}
+
+// clientTransport returns the transport an api client created from apiConf would use, including its TLS settings
+func clientTransport(apiConf *api.Config) (http.RoundTripper, error) {
+	if apiConf.HttpClient != nil && apiConf.HttpClient.Transport != nil {
+		return apiConf.HttpClient.Transport, nil
+	}
+	client, err := api.NewHttpClient(apiConf.Transport, apiConf.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	return client.Transport, nil
+}
+
+func (rr *roundRobinTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rr.cl.m.Lock()
+	addrs := rr.cl.liveAddrs()
+	rr.cl.m.Unlock()
+
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("cluster \"%s\" has no live instances", rr.cl.name)
+	}
+
+	addr := addrs[(atomic.AddUint32(&rr.next, 1)-1)%uint32(len(addrs))]
+
+	out := req.Clone(req.Context())
+	out.URL.Scheme = addr.scheme
+	out.URL.Host = addr.hostport
+	out.Host = addr.hostport
+
+	return rr.base.RoundTrip(out)
+}
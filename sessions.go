@@ -0,0 +1,59 @@
+package agentman
+
+import (
+	"fmt"
+	"github.com/hashicorp/consul/api"
+)
+
+// CreateSession will attempt to create a session as described by se, returning its id.  A nil se creates a session
+// with consul's defaults, tied to the serf health of this instance's node.
+func (ti *TestInstance) CreateSession(se *api.SessionEntry) (string, error) {
+	client, ok := ti.apiClient()
+	if !ok {
+		return "", ti.defunctErr()
+	}
+	id, _, err := client.Session().Create(se, nil)
+	return id, err
+}
+
+// DestroySession will attempt to destroy session id, releasing any locks held by it
+func (ti *TestInstance) DestroySession(id string) error {
+	client, ok := ti.apiClient()
+	if !ok {
+		return ti.defunctErr()
+	}
+	_, err := client.Session().Destroy(id, nil)
+	return err
+}
+
+// RenewSession will attempt to renew the TTL of session id.  An error is returned should the session no longer exist.
+func (ti *TestInstance) RenewSession(id string) error {
+	client, ok := ti.apiClient()
+	if !ok {
+		return ti.defunctErr()
+	}
+	entry, _, err := client.Session().Renew(id, nil)
+	if err == nil && entry == nil {
+		return fmt.Errorf("session \"%s\" does not exist", id)
+	}
+	return err
+}
+
+// AcquireLock will attempt to acquire the lock at key via this instance, blocking until it is held.  The lock is backed
+// by a session created for it, which is renewed for as long as the lock is held.  Release the lock with Unlock, and
+// then call Destroy should the key no longer be needed.  Should the session be invalidated while held, for example by
+// the instance being stopped, the lock is lost without notice.
+func (ti *TestInstance) AcquireLock(key string) (*api.Lock, error) {
+	client, ok := ti.apiClient()
+	if !ok {
+		return nil, ti.defunctErr()
+	}
+	lock, err := client.LockKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = lock.Lock(nil); err != nil {
+		return nil, fmt.Errorf("unable to acquire lock \"%s\": %s", key, err)
+	}
+	return lock, nil
+}
@@ -0,0 +1,128 @@
+package agentman
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// StartSnapshotLoop will take a snapshot of the cluster state via this instance every interval, writing each to a
+// timestamped file within dir, until ctx is cancelled or the instance is stopped.  A failed cycle does not end the
+// loop; its error is sent on the returned channel instead, and dropped should the channel be full.  The channel is
+// closed once the loop exits.
+func (ti *TestInstance) StartSnapshotLoop(ctx context.Context, interval time.Duration, dir string) (<-chan error, error) {
+	if interval <= 0 {
+		return nil, errors.New("interval must be greater than 0")
+	}
+	if ti.Stopped() {
+		return nil, ti.defunctErr()
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("unable to create snapshot dir \"%s\": %s", dir, err)
+	}
+
+	errCh := make(chan error, 10)
+
+	go func() {
+		defer close(errCh)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			err := ti.writeSnapshot(dir)
+			if err != nil {
+				select {
+				case errCh <- err:
+				default:
+				}
+			}
+			if errors.Is(err, ErrInstanceDefunct) {
+				return
+			}
+		}
+	}()
+
+	return errCh, nil
+}
+
+// writeSnapshot saves a single snapshot to a timestamped file within dir
+func (ti *TestInstance) writeSnapshot(dir string) error {
+	client, ok := ti.apiClient()
+	if !ok {
+		return ti.defunctErr()
+	}
+
+	snap, _, err := client.Snapshot().Save(nil)
+	if err != nil {
+		return fmt.Errorf("unable to take snapshot of instance \"%s\": %s", ti.name, err)
+	}
+	defer snap.Close()
+
+	name := filepath.Join(dir, fmt.Sprintf("%s-%s.snap", ti.name, time.Now().UTC().Format("20060102T150405.000000000Z")))
+	f, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("unable to create snapshot file \"%s\": %s", name, err)
+	}
+	defer f.Close()
+
+	if _, err = io.Copy(f, snap); err != nil {
+		return fmt.Errorf("unable to write snapshot file \"%s\": %s", name, err)
+	}
+
+	return nil
+}
+
+// Snapshot will attempt to capture a snapshot of the cluster state via the current leader
+func (cl *TestCluster) Snapshot() ([]byte, error) {
+	cl.m.Lock()
+	defer cl.m.Unlock()
+	if cl.stopped {
+		return nil, fmt.Errorf("cluster \"%s\" is defunct", cl.name)
+	}
+
+	client, err := cl.leaderClient()
+	if err != nil {
+		return nil, err
+	}
+
+	snap, _, err := client.Snapshot().Save(nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to take snapshot of cluster \"%s\": %s", cl.name, err)
+	}
+	defer snap.Close()
+
+	return ioutil.ReadAll(snap)
+}
+
+// RestoreSnapshot will attempt to restore a snapshot, such as one returned by Snapshot, via the current leader.  This
+// replaces the entire state of the cluster.
+func (cl *TestCluster) RestoreSnapshot(data []byte) error {
+	cl.m.Lock()
+	defer cl.m.Unlock()
+	if cl.stopped {
+		return fmt.Errorf("cluster \"%s\" is defunct", cl.name)
+	}
+
+	client, err := cl.leaderClient()
+	if err != nil {
+		return err
+	}
+
+	if err = client.Snapshot().Restore(nil, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("unable to restore snapshot to cluster \"%s\": %s", cl.name, err)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package agentman
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// SupervisePollInterval is how often a supervised instance's process is checked
+var SupervisePollInterval = time.Second
+
+// SetAutoRestart enables or disables supervision of single instance name.  While supervised, should the instance's
+// consul process exit without the instance having been stopped, it is restarted from its data dir with the same
+// config.  OnStop and OnStart handlers are notified as the instance goes down and comes back up.  Stopping the
+// instance, whether directly or via StopInstance, Stop, or ReplaceInstance, ends supervision.
+func (am *AgentMan) SetAutoRestart(name string, enabled bool) error {
+	am.m.Lock()
+	defer am.m.Unlock()
+
+	if !enabled {
+		am.unsupervise(name)
+		return nil
+	}
+
+	instance, ok := am.instances[name]
+	if !ok {
+		if owner, owned := am.clusterOwning(name); owned {
+			return fmt.Errorf("unable to supervise \"%s\", a member of cluster \"%s\": %w", name, owner, ErrInstanceOwnedByCluster)
+		}
+		return fmt.Errorf("instance \"%s\" does not exist", name)
+	}
+	if _, ok := am.supervisors[name]; ok {
+		return nil
+	}
+
+	done := make(chan struct{})
+	am.supervisors[name] = done
+
+	go am.supervise(name, instance, done)
+
+	return nil
+}
+
+// unsupervise ends supervision of instance name, if any.  Caller must hold lock.
+func (am *AgentMan) unsupervise(name string) {
+	if done, ok := am.supervisors[name]; ok {
+		close(done)
+		delete(am.supervisors, name)
+	}
+}
+
+// supervise watches the process of instance until done is closed or the instance is stopped
+func (am *AgentMan) supervise(name string, instance *TestInstance, done chan struct{}) {
+	defer func() {
+		am.m.Lock()
+		if am.supervisors[name] == done {
+			am.unsupervise(name)
+		}
+		am.m.Unlock()
+	}()
+
+	ticker := time.NewTicker(SupervisePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		if instance.Stopped() {
+			return
+		}
+
+		_, err := findPID(instance.DataDir())
+		if !errors.Is(err, errProcessNotFound) {
+			continue
+		}
+
+		err = instance.respawn()
+		if errors.Is(err, ErrInstanceDefunct) {
+			// stopped while being checked, whoever stopped it notifies
+			return
+		}
+		am.hooks.stopped(name)
+		if err != nil {
+			log.Printf("agentman: unable to restart instance \"%s\" after its process exited: %s", name, err)
+			return
+		}
+		log.Printf("agentman: restarted instance \"%s\" after its process exited", name)
+		am.hooks.started(name)
+	}
+}
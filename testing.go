@@ -0,0 +1,91 @@
+package agentman
+
+import (
+	"bytes"
+	"github.com/hashicorp/consul/testutil"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// NewTestInstanceT creates a test instance for the duration of test t, failing t immediately should creation fail.
+// The instance is stopped once t and its subtests complete, and the agent's output is logged via t.Log, overriding
+// any Stdout and Stderr set by cb.  Should t fail, the instance's files are kept if WithRetainDataOnFailure is set.
+func NewTestInstanceT(t *testing.T, name string, cb testutil.ServerConfigCallback, opts ...Option) *TestInstance {
+	t.Helper()
+
+	instance, err := NewTestInstance(name, func(conf *testutil.TestServerConfig) {
+		if cb != nil {
+			cb(conf)
+		}
+		conf.Stdout = newTestLogWriter(t, name)
+		conf.Stderr = newTestLogWriter(t, name)
+	}, opts...)
+	if err != nil {
+		t.Fatalf("Unable to create instance \"%s\": %s", name, err)
+	}
+
+	t.Cleanup(func() {
+		if err := instance.stop(t.Failed()); err != nil {
+			t.Logf("Error stopping instance \"%s\": %s", name, err)
+		}
+	})
+
+	return instance
+}
+
+// NewTestClusterT creates a test cluster for the duration of test t, failing t immediately should creation fail.  The
+// cluster is stopped once t and its subtests complete, and the output of each member is logged via t.Log, overriding
+// any Stdout and Stderr set by cb.
+func NewTestClusterT(t *testing.T, name string, size uint8, cb ClusterServerConfigCallback, opts ...Option) *TestCluster {
+	t.Helper()
+
+	if cb == nil {
+		cb = DefaultClusterServerConfigCallback
+	}
+
+	cluster, err := NewTestCluster(name, size, func(name string, num uint8, conf *testutil.TestServerConfig) {
+		cb(name, num, conf)
+		member := ClusterInstanceName(name, num)
+		conf.Stdout = newTestLogWriter(t, member)
+		conf.Stderr = newTestLogWriter(t, member)
+	}, opts...)
+	if err != nil {
+		t.Fatalf("Unable to create cluster \"%s\": %s", name, err)
+	}
+
+	t.Cleanup(func() {
+		if err := cluster.Stop(); err != nil {
+			t.Logf("Error stopping cluster \"%s\": %s", name, err)
+		}
+	})
+
+	return cluster
+}
+
+// testLogWriter logs each complete line written to it via t.Log, prefixed with the name of the instance
+type testLogWriter struct {
+	m      sync.Mutex
+	t      *testing.T
+	prefix string
+	buf    bytes.Buffer
+}
+
+func newTestLogWriter(t *testing.T, name string) *testLogWriter {
+	return &testLogWriter{t: t, prefix: "[" + name + "] "}
+}
+
+func (w *testLogWriter) Write(p []byte) (int, error) {
+	w.m.Lock()
+	defer w.m.Unlock()
+	w.buf.Write(p)
+	for {
+		line, err := w.buf.ReadString('\n')
+		if err != nil {
+			// incomplete line, hold on to it until the rest arrives
+			w.buf.WriteString(line)
+			return len(p), nil
+		}
+		w.t.Log(w.prefix + strings.TrimRight(line, "\r\n"))
+	}
+}
@@ -0,0 +1,106 @@
+package agentman
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RenderTopologyDOT renders the instances and clusters managed by am as a Graphviz DOT graph.  Each cluster is drawn
+// as a subgraph of its members, and nodes are labeled with their name and HTTP address.  Where instances have been
+// WAN joined to one another, as seen from their WAN member lists, they are linked by an edge.
+func RenderTopologyDOT(am *AgentMan) (string, error) {
+	if am == nil {
+		return "", errors.New("manager is nil")
+	}
+
+	singles := am.instanceList()
+	clusters := am.clusterList()
+
+	sort.Slice(singles, func(i, j int) bool { return singles[i].Name() < singles[j].Name() })
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].Name() < clusters[j].Name() })
+
+	all := append(make([]*TestInstance, 0, len(singles)), singles...)
+
+	b := new(strings.Builder)
+	b.WriteString("graph agentman {\n")
+
+	for _, instance := range singles {
+		writeDOTNode(b, "\t", instance)
+	}
+
+	for i, cluster := range clusters {
+		fmt.Fprintf(b, "\tsubgraph cluster_%d {\n", i)
+		fmt.Fprintf(b, "\t\tlabel=%q;\n", cluster.Name())
+		for _, instance := range cluster.instanceList() {
+			writeDOTNode(b, "\t\t", instance)
+			all = append(all, instance)
+		}
+		b.WriteString("\t}\n")
+	}
+
+	for _, edge := range wanEdges(all) {
+		fmt.Fprintf(b, "\t%q -- %q [style=dashed, label=\"wan\"];\n", edge[0], edge[1])
+	}
+
+	b.WriteString("}\n")
+
+	return b.String(), nil
+}
+
+func writeDOTNode(b *strings.Builder, indent string, instance *TestInstance) {
+	if _, hostport, err := instance.Addr(); err == nil {
+		fmt.Fprintf(b, "%s%q [label=%q];\n", indent, instance.Name(), instance.Name()+"\n"+hostport)
+	} else {
+		fmt.Fprintf(b, "%s%q [label=%q, style=dotted];\n", indent, instance.Name(), instance.Name()+"\n(stopped)")
+	}
+}
+
+// wanEdges returns each pair of live instances where one sees the other in its WAN member list
+func wanEdges(instances []*TestInstance) [][2]string {
+	byWANAddr := make(map[string]string, len(instances))
+	for _, instance := range instances {
+		if addr, ok := instance.wanAddr(); ok {
+			byWANAddr[addr] = instance.Name()
+		}
+	}
+
+	seen := make(map[[2]string]struct{})
+	edges := make([][2]string, 0)
+	for _, instance := range instances {
+		client, ok := instance.apiClient()
+		if !ok {
+			continue
+		}
+		members, err := client.Agent().Members(true)
+		if err != nil {
+			continue
+		}
+		for _, member := range members {
+			peer, ok := byWANAddr[net.JoinHostPort(member.Addr, strconv.Itoa(int(member.Port)))]
+			if !ok || peer == instance.Name() {
+				continue
+			}
+			edge := [2]string{instance.Name(), peer}
+			if edge[0] > edge[1] {
+				edge[0], edge[1] = edge[1], edge[0]
+			}
+			if _, ok := seen[edge]; !ok {
+				seen[edge] = struct{}{}
+				edges = append(edges, edge)
+			}
+		}
+	}
+
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i][0] == edges[j][0] {
+			return edges[i][1] < edges[j][1]
+		}
+		return edges[i][0] < edges[j][0]
+	})
+
+	return edges
+}